@@ -0,0 +1,219 @@
+package standard
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/elecbug/netkit/v2/graph"
+)
+
+// Attachment represents the strategy used to choose the honest nodes a Sybil node links to.
+type Attachment string
+
+const (
+	AttachRandom       Attachment = "random"       // uniformly random honest nodes
+	AttachPreferential Attachment = "preferential" // honest nodes chosen with a probability proportional to their degree plus one
+	AttachTargeted     Attachment = "targeted"     // the given target nodes only
+)
+
+// SybilConfig represents a configuration for injecting Sybil nodes into a graph.
+type SybilConfig struct {
+	Count        int            // number of Sybil nodes to add
+	Links        int            // number of honest nodes each Sybil node links to
+	Attachment   Attachment     // strategy used to choose the honest nodes; empty means AttachRandom
+	Targets      []graph.NodeID // nodes the Sybil nodes link to with AttachTargeted
+	Prefix       string         // prefix of the Sybil node IDs, followed by the lowest indices not used by other nodes; empty means "sybil-"
+	Interconnect bool           // indicates whether the Sybil nodes are also linked to each other
+}
+
+// Eclipse rewires the graph so that all neighbors of the victim are attackers: every edge between the victim and
+// a node that is not an attacker is removed, and the victim is linked to every attacker it is not already linked to.
+// In a directed graph, edges are removed and added in both directions.
+// If weightFunc is nil, new edges have no weight. Otherwise, weightFunc will be called for each new edge.
+func Eclipse(g *graph.Graph, weightFunc WeightedFunc, victim graph.NodeID, attackers []graph.NodeID) error {
+	if len(attackers) == 0 {
+		return fmt.Errorf("at least one attacker is required")
+	}
+	if !g.HasNode(victim) {
+		return fmt.Errorf("node %s does not exist", victim)
+	}
+
+	for _, id := range attackers {
+		if id == victim {
+			return fmt.Errorf("victim %s cannot be an attacker", victim)
+		}
+		if !g.HasNode(id) {
+			return fmt.Errorf("node %s does not exist", id)
+		}
+	}
+
+	for _, id := range g.Nodes() {
+		if id == victim || slices.Contains(attackers, id) {
+			continue
+		}
+
+		if g.HasEdge(victim, id) {
+			if err := g.RemoveEdge(victim, id); err != nil {
+				return fmt.Errorf("failed to remove edge: %w", err)
+			}
+		}
+		if g.IsDirected() && g.HasEdge(id, victim) {
+			if err := g.RemoveEdge(id, victim); err != nil {
+				return fmt.Errorf("failed to remove edge: %w", err)
+			}
+		}
+	}
+
+	for _, id := range attackers {
+		if err := addEdge(g, weightFunc, victim, id); err != nil {
+			return err
+		}
+		if g.IsDirected() {
+			if err := addEdge(g, weightFunc, id, victim); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AddSybils adds config.Count Sybil nodes to the graph and links each of them to config.Links honest nodes, which are
+// the nodes of the graph before the call, chosen with config.Attachment. In a directed graph, edges go from the Sybil
+// nodes to the honest nodes. It returns the IDs of the Sybil nodes.
+// If weightFunc is nil, new edges have no weight. Otherwise, weightFunc will be called for each new edge.
+func AddSybils(seed int, g *graph.Graph, weightFunc WeightedFunc, config SybilConfig) ([]graph.NodeID, error) {
+	if config.Count < 0 {
+		return nil, fmt.Errorf("invalid number of Sybil nodes: count must be non-negative")
+	}
+	if config.Links < 0 {
+		return nil, fmt.Errorf("invalid number of links: links must be non-negative")
+	}
+
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "sybil-"
+	}
+
+	honest := g.Nodes()
+
+	switch config.Attachment {
+	case "", AttachRandom, AttachPreferential:
+	case AttachTargeted:
+		if len(config.Targets) == 0 {
+			return nil, fmt.Errorf("targeted attachment requires at least one target")
+		}
+		for _, id := range config.Targets {
+			if !g.HasNode(id) {
+				return nil, fmt.Errorf("node %s does not exist", id)
+			}
+		}
+
+		honest = slices.Clone(config.Targets)
+		slices.Sort(honest)
+		honest = slices.Compact(honest)
+	default:
+		return nil, fmt.Errorf("unsupported attachment: %s", config.Attachment)
+	}
+
+	r := generateRand(seed)
+	sybils := make([]graph.NodeID, 0, config.Count)
+	index := 0
+
+	for i := 0; i < config.Count; i++ {
+		// skip the IDs of nodes already in the graph, such as the Sybil nodes of a previous call
+		id := graph.NodeID(fmt.Sprintf("%s%d", prefix, index))
+		for g.HasNode(id) {
+			index++
+			id = graph.NodeID(fmt.Sprintf("%s%d", prefix, index))
+		}
+		index++
+
+		if err := g.AddNode(id); err != nil {
+			return nil, fmt.Errorf("failed to add node: %w", err)
+		}
+
+		links := min(config.Links, len(honest))
+		targets := make([]graph.NodeID, 0, links)
+
+		if config.Attachment == AttachPreferential {
+			// degree based sampling without replacement, plus one so that isolated nodes can be chosen
+			weights := make([]int, len(honest))
+			total := 0
+			for j, h := range honest {
+				node, err := g.Node(h)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get node: %w", err)
+				}
+				weights[j] = node.Degree() + 1
+				total += weights[j]
+			}
+
+			for len(targets) < links {
+				x := r.Intn(total)
+				for j, w := range weights {
+					if x < w {
+						targets = append(targets, honest[j])
+						total -= w
+						weights[j] = 0
+						break
+					}
+					x -= w
+				}
+			}
+		} else {
+			for _, j := range r.Perm(len(honest))[:links] {
+				targets = append(targets, honest[j])
+			}
+		}
+
+		for _, target := range targets {
+			if err := addEdge(g, weightFunc, id, target); err != nil {
+				return nil, err
+			}
+		}
+
+		sybils = append(sybils, id)
+	}
+
+	if config.Interconnect {
+		for _, a := range sybils {
+			for _, b := range sybils {
+				if a == b || (!g.IsDirected() && a > b) {
+					continue
+				}
+				if err := addEdge(g, weightFunc, a, b); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return sybils, nil
+}
+
+// addEdge adds an edge from one node to another unless it already exists, with a weight generated by weightFunc.
+func addEdge(g *graph.Graph, weightFunc WeightedFunc, from, to graph.NodeID) error {
+	if g.HasEdge(from, to) {
+		return nil
+	}
+
+	var weight *graph.Weight
+	if weightFunc != nil {
+		fromNode, err := g.Node(from)
+		if err != nil {
+			return fmt.Errorf("failed to get node: %w", err)
+		}
+		toNode, err := g.Node(to)
+		if err != nil {
+			return fmt.Errorf("failed to get node: %w", err)
+		}
+		weight = weightFunc(fromNode, toNode)
+	}
+
+	if err := g.AddEdge(from, to, weight); err != nil {
+		return fmt.Errorf("failed to add edge: %w", err)
+	}
+
+	return nil
+}
@@ -59,9 +59,10 @@ func BarabasiAlbertGraph(seed int, directed bool, weightFunc WeightedFunc, n int
 		}
 
 		// calculate current node degrees
+		ids := g.Nodes()
 		degrees := make(map[graph.NodeID]int)
 		totalDegree := 0
-		for _, id := range g.Nodes() {
+		for _, id := range ids {
 			node, err := g.Node(id)
 			if err != nil {
 				return nil, fmt.Errorf("failed to get node: %w", err)
@@ -77,8 +78,9 @@ func BarabasiAlbertGraph(seed int, directed bool, weightFunc WeightedFunc, n int
 			r := r.Intn(totalDegree)
 			accum := 0
 			var target graph.NodeID
-			for id, d := range degrees {
-				accum += d
+			// walk nodes in sorted order so that the same seed generates the same graph
+			for _, id := range ids {
+				accum += degrees[id]
 				if r < accum {
 					target = id
 					break
@@ -3,6 +3,7 @@ package standard
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
 
 	"github.com/elecbug/netkit/v2/graph"
 )
@@ -45,6 +46,12 @@ func generateRand(seed int) *rand.Rand {
 	return r
 }
 
+// tagPosition stores the position of a node in its "x" and "y" tags, with full precision.
+func tagPosition(node *graph.Node, x, y float64) {
+	node.UpdateTag("x", strconv.FormatFloat(x, 'f', -1, 64))
+	node.UpdateTag("y", strconv.FormatFloat(y, 'f', -1, 64))
+}
+
 // StandardGraph generates a graph based on the provided configuration. It supports various graph types and parameters.
 // If weightFunc is nil, all edges will have no weight (unweighted graph). Otherwise, weightFunc will be called for
 // each new edge with the new node and the target node as arguments.
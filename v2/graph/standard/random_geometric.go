@@ -10,6 +10,7 @@ import (
 // RandomGeometricGraph generates a random geometric graph (RGG).
 // Nodes are placed uniformly at random in the unit square, and edges
 // are added between nodes that are within a specified radius r.
+// The position of each node is stored in its "x" and "y" tags.
 // If weightFunc is nil, all edges will have no weight (unweighted graph). Otherwise, weightFunc will be called for
 // each new edge with the new node and the target node as arguments.
 func RandomGeometricGraph(seed int, directed bool, weightFunc WeightedFunc, n int, r float64) (*graph.Graph, error) {
@@ -38,16 +39,15 @@ func RandomGeometricGraph(seed int, directed bool, weightFunc WeightedFunc, n in
 		if err := g.AddNode(id); err != nil {
 			return nil, fmt.Errorf("failed to add node: %w", err)
 		}
-		if node, err := g.Node(id); err != nil {
-			return nil, fmt.Errorf("failed to retrieve node: %w", err)
-		} else {
-			node.AddTag("x", fmt.Sprintf("%f", rr.Float64()))
-			node.AddTag("y", fmt.Sprintf("%f", rr.Float64()))
-		}
 		positions[id] = point{
 			x: rr.Float64(),
 			y: rr.Float64(),
 		}
+		if node, err := g.Node(id); err != nil {
+			return nil, fmt.Errorf("failed to retrieve node: %w", err)
+		} else {
+			tagPosition(node, positions[id].x, positions[id].y)
+		}
 	}
 
 	// --- 2. Generate Edges ---
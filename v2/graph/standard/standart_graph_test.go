@@ -3,6 +3,7 @@ package standard_test
 import (
 	"fmt"
 	"math"
+	"slices"
 	"sync"
 	"testing"
 
@@ -24,6 +25,12 @@ func TestStandardGraph(t *testing.T) {
 	testGenerateFromConfig(t)
 }
 
+func TestAttacks(t *testing.T) {
+	fmt.Println("Test Attack Scenarios")
+	testEclipse(t)
+	testAddSybils(t)
+}
+
 // testBarabasiAlbertGraph tests the Barabási-Albert graph generation function.
 func testBarabasiAlbertGraph(t *testing.T) {
 	fmt.Println("- Test Barabási-Albert Graph")
@@ -727,6 +734,77 @@ func testGenerateFromConfig(t *testing.T) {
 	}
 }
 
+// testEclipse tests that an eclipsed node is linked to the attackers only.
+func testEclipse(t *testing.T) {
+	fmt.Println("- Test Eclipse")
+
+	g, err := standard.BarabasiAlbertGraph(1, false, nil, 100, 3)
+	if err != nil {
+		t.Fatalf("failed to generate Barabási-Albert graph: %v", err)
+	}
+
+	if err := standard.Eclipse(g, nil, "50", []graph.NodeID{"1", "2", "3"}); err != nil {
+		t.Fatalf("failed to eclipse node: %v", err)
+	}
+
+	victim, err := g.Node("50")
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+
+	neighbors := victim.Neighbors()
+	slices.Sort(neighbors)
+	if !slices.Equal(neighbors, []graph.NodeID{"1", "2", "3"}) {
+		t.Errorf("expected only attackers as neighbors, got %v", neighbors)
+	}
+
+	if err := standard.Eclipse(g, nil, "50", []graph.NodeID{"50"}); err == nil {
+		t.Errorf("expected error for a victim among the attackers, but got none")
+	}
+}
+
+// testAddSybils tests the attachment of Sybil nodes and the IDs of repeated injections.
+func testAddSybils(t *testing.T) {
+	fmt.Println("- Test AddSybils")
+
+	g, err := standard.BarabasiAlbertGraph(1, false, nil, 100, 3)
+	if err != nil {
+		t.Fatalf("failed to generate Barabási-Albert graph: %v", err)
+	}
+
+	sybils, err := standard.AddSybils(1, g, nil, standard.SybilConfig{
+		Count:        5,
+		Links:        2,
+		Attachment:   standard.AttachTargeted,
+		Targets:      []graph.NodeID{"50"},
+		Interconnect: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to add Sybil nodes: %v", err)
+	}
+	if len(sybils) != 5 || g.Size() != 105 {
+		t.Fatalf("expected 5 Sybil nodes, got %v", sybils)
+	}
+
+	for _, id := range sybils {
+		if n, _ := g.Node(id); !g.HasEdge(id, "50") || n.Degree() != 5 {
+			t.Errorf("expected Sybil node %s to link to the target and the other Sybil nodes", id)
+		}
+	}
+
+	more, err := standard.AddSybils(2, g, nil, standard.SybilConfig{Count: 2, Links: 3})
+	if err != nil {
+		t.Fatalf("failed to add Sybil nodes again: %v", err)
+	}
+	if !slices.Equal(more, []graph.NodeID{"sybil-5", "sybil-6"}) || g.Size() != 107 {
+		t.Errorf("expected unused IDs for repeated Sybil nodes, got %v", more)
+	}
+
+	if _, err := standard.AddSybils(1, g, nil, standard.SybilConfig{Count: 1, Attachment: standard.AttachTargeted}); err == nil {
+		t.Errorf("expected error for targeted attachment without targets, but got none")
+	}
+}
+
 // checkRange checks if value is within the range of target*(1-lower) and target*(1+upper).
 func checkRange(value, target float64, lower, upper float64) bool {
 	return value >= target*(1-lower) && value <= target*(1+upper)
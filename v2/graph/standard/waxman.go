@@ -27,6 +27,7 @@ func waxmanDistance(a, b waxmanPoint) float64 {
 // L is the maximum Euclidean distance among all node pairs.
 // alpha controls edge density.
 // beta controls locality sensitivity.
+// The position of each node is stored in its "x" and "y" tags.
 func WaxmanGraph(seed int, directed bool, weightFunc WeightedFunc, n int, alpha, beta float64) (*graph.Graph, error) {
 	if n < 0 {
 		return nil, fmt.Errorf("invalid number of nodes: n must be non-negative")
@@ -61,6 +62,12 @@ func WaxmanGraph(seed int, directed bool, weightFunc WeightedFunc, n int, alpha,
 			x: r.Float64(),
 			y: r.Float64(),
 		}
+
+		if node, err := g.Node(id); err != nil {
+			return nil, fmt.Errorf("failed to retrieve node: %w", err)
+		} else {
+			tagPosition(node, points[i].x, points[i].y)
+		}
 	}
 
 	if n <= 1 {
@@ -0,0 +1,189 @@
+package p2p
+
+import (
+	"sync"
+)
+
+// AnnounceConfig enables two-phase propagation in the style of INV/GETDATA: instead of sending a message to the
+// targets chosen by its ProtocolFunc, a peer announces the message ID to them, and sends the message only to the
+// peers that request it. A peer requests an announced message from the first peer that announced it, and, if the
+// message does not arrive within RequestTimeout, from the next one. Announcements are not sent to peers known
+// to have the message, because they announced it or sent it.
+//
+// Announcements and requests are recorded in the trace with their kind; only the messages themselves count as
+// sent in the statistics, so that the bandwidth saved by announcing can be compared with the latency it adds.
+type AnnounceConfig struct {
+	AnnounceSize   int     // size of an announcement in bytes, used to compute transmission delays
+	RequestSize    int     // size of a request in bytes, used to compute transmission delays
+	RequestTimeout float64 // time to wait for a requested message before requesting it from another peer, in milliseconds; zero waits forever
+}
+
+// announcer is the per-peer handler implementing two-phase propagation.
+type announcer struct {
+	cfg *AnnounceConfig
+
+	known     map[string]map[PeerID]struct{} // message ID -> peers known to have the message
+	pending   map[string]map[PeerID]Message  // message ID -> message to send to each peer it was announced to
+	requested map[string]bool                // message ID -> whether a request is outstanding
+	announced map[string][]PeerID            // message ID -> peers that announced the message and were not asked yet
+	mu        sync.Mutex                     // mutex to protect access to the handler's state
+}
+
+// newAnnouncer creates an announcer with empty state.
+func newAnnouncer(cfg *AnnounceConfig) *announcer {
+	return &announcer{
+		cfg:       cfg,
+		known:     make(map[string]map[PeerID]struct{}),
+		pending:   make(map[string]map[PeerID]Message),
+		requested: make(map[string]bool),
+		announced: make(map[string][]PeerID),
+	}
+}
+
+// start does nothing; announcements are only sent when messages are forwarded.
+func (a *announcer) start(network *P2P, p *peer) {}
+
+// forward announces the ID of msg to the target, and keeps msg to send it once requested.
+func (a *announcer) forward(network *P2P, p *peer, targetID PeerID, msg Message) {
+	a.mu.Lock()
+
+	if _, ok := a.known[msg.ID][targetID]; ok {
+		a.mu.Unlock()
+		return
+	}
+
+	a.markKnown(msg.ID, targetID)
+
+	if _, ok := a.pending[msg.ID]; !ok {
+		a.pending[msg.ID] = make(map[PeerID]Message)
+	}
+	a.pending[msg.ID][targetID] = msg
+
+	a.mu.Unlock()
+
+	p.send(network, targetID, Message{
+		Kind:      KindAnnounce,
+		Publisher: msg.Publisher,
+		ID:        msg.ID,
+		Size:      a.cfg.AnnounceSize,
+		HopCount:  msg.HopCount,
+	})
+}
+
+// receive handles announcements, requests and the requested messages.
+func (a *announcer) receive(network *P2P, p *peer, msg Message, first bool) {
+	switch msg.Kind {
+	case KindPayload:
+		a.mu.Lock()
+		a.markKnown(msg.ID, msg.From)
+		delete(a.requested, msg.ID)
+		delete(a.announced, msg.ID)
+		a.mu.Unlock()
+
+	case KindAnnounce:
+		a.mu.Lock()
+		a.markKnown(msg.ID, msg.From)
+
+		if p.hasSeen(msg.ID) {
+			a.mu.Unlock()
+			return
+		}
+
+		if a.requested[msg.ID] {
+			a.announced[msg.ID] = append(a.announced[msg.ID], msg.From)
+			a.mu.Unlock()
+			return
+		}
+
+		a.requested[msg.ID] = true
+		a.mu.Unlock()
+
+		a.request(network, p, msg.ID, msg.From)
+
+	case KindRequest:
+		a.mu.Lock()
+		out, ok := a.pending[msg.ID][msg.From]
+		a.dropPending(msg.ID, msg.From)
+		a.mu.Unlock()
+
+		if !ok {
+			p.mu.Lock()
+			out, ok = p.messages[msg.ID]
+			p.mu.Unlock()
+
+			if !ok {
+				return
+			}
+
+			out.HopCount++
+			out.DynamicParams = nil
+		}
+
+		p.send(network, msg.From, out)
+	}
+}
+
+// request asks the source for the message and, with a request timeout, schedules a request to the next
+// announcing peer in case the message does not arrive in time.
+func (a *announcer) request(network *P2P, p *peer, msgID string, sourceID PeerID) {
+	p.send(network, sourceID, Message{
+		Kind: KindRequest,
+		ID:   msgID,
+		Size: a.cfg.RequestSize,
+	})
+
+	if a.cfg.RequestTimeout <= 0 {
+		return
+	}
+
+	network.after(a.cfg.RequestTimeout, func() {
+		if p.hasSeen(msgID) {
+			return
+		}
+
+		a.mu.Lock()
+
+		if !p.isAlive() || len(a.announced[msgID]) == 0 {
+			a.requested[msgID] = false
+			a.mu.Unlock()
+			return
+		}
+
+		next := a.announced[msgID][0]
+		a.announced[msgID] = a.announced[msgID][1:]
+		a.mu.Unlock()
+
+		a.request(network, p, msgID, next)
+	})
+}
+
+// markKnown records that the peer has the message, which no longer needs to be kept for it.
+// The caller must hold the handler's mutex.
+func (a *announcer) markKnown(msgID string, id PeerID) {
+	if _, ok := a.known[msgID]; !ok {
+		a.known[msgID] = make(map[PeerID]struct{})
+	}
+
+	a.known[msgID][id] = struct{}{}
+	a.dropPending(msgID, id)
+}
+
+// dropPending forgets the message kept for the peer, once it was sent or the peer has it.
+// The caller must hold the handler's mutex.
+func (a *announcer) dropPending(msgID string, id PeerID) {
+	delete(a.pending[msgID], id)
+
+	if len(a.pending[msgID]) == 0 {
+		delete(a.pending, msgID)
+	}
+}
+
+// hasSeen reports whether the peer has received the message with the given ID.
+func (p *peer) hasSeen(msgID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.seenAt[msgID]
+
+	return ok
+}
@@ -0,0 +1,104 @@
+package p2p
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Deanonymization summarizes how well an adversary controlling a set of spy peers identifies the publishers of
+// messages with the first-spy estimator. Messages published by spies are not counted.
+type Deanonymization struct {
+	Messages  int               `json:"messages"`  // number of messages published by honest peers
+	Observed  int               `json:"observed"`  // number of those messages received by at least one spy
+	Correct   int               `json:"correct"`   // number of messages whose publisher was identified
+	Precision float64           `json:"precision"` // fraction of the observed messages whose publisher was identified
+	Recall    float64           `json:"recall"`    // fraction of all messages whose publisher was identified
+	Estimates map[string]PeerID `json:"estimates"` // message ID -> estimated publisher of every observed message
+}
+
+// FirstSpy estimates the publisher of a message with the first-spy estimator: among the spies, the one that received
+// the message first, breaking ties by peer ID, guesses that it was published by the peer it received it from.
+// It returns false if no spy received the message, and an error if the message was not published or a spy does not exist.
+func (p *P2P) FirstSpy(msg string, spies []PeerID) (PeerID, bool, error) {
+	p.mu.RLock()
+	_, ok := p.published[msg]
+	p.mu.RUnlock()
+
+	if !ok {
+		return "", false, fmt.Errorf("message %s was not published", msg)
+	}
+
+	observations := make([]Reception, 0, len(spies))
+
+	for _, id := range spies {
+		spy, ok := p.peer(id)
+		if !ok {
+			return "", false, fmt.Errorf("peer %s not found", id)
+		}
+
+		spy.mu.Lock()
+		if t, ok := spy.seenAt[msg]; ok {
+			observations = append(observations, Reception{PeerID: id, From: spy.firstFrom[msg], Timestamp: t})
+		}
+		spy.mu.Unlock()
+	}
+
+	if len(observations) == 0 {
+		return "", false, nil
+	}
+
+	first := slices.MinFunc(observations, func(a, b Reception) int {
+		if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.PeerID, b.PeerID)
+	})
+
+	return first.From, true, nil
+}
+
+// Deanonymize applies the first-spy estimator to every given message and compares the estimates with the actual publishers.
+func (p *P2P) Deanonymize(msgs []string, spies []PeerID) (*Deanonymization, error) {
+	d := &Deanonymization{Estimates: make(map[string]PeerID)}
+
+	for _, msg := range msgs {
+		p.mu.RLock()
+		pub, ok := p.published[msg]
+		p.mu.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("message %s was not published", msg)
+		}
+		if slices.Contains(spies, pub.publisher) {
+			continue
+		}
+
+		d.Messages++
+
+		estimate, observed, err := p.FirstSpy(msg, spies)
+		if err != nil {
+			return nil, err
+		}
+		if !observed {
+			continue
+		}
+
+		d.Observed++
+		d.Estimates[msg] = estimate
+
+		if estimate == pub.publisher {
+			d.Correct++
+		}
+	}
+
+	if d.Observed > 0 {
+		d.Precision = float64(d.Correct) / float64(d.Observed)
+	}
+	if d.Messages > 0 {
+		d.Recall = float64(d.Correct) / float64(d.Messages)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,121 @@
+package p2p
+
+import (
+	"slices"
+)
+
+// AntiEntropyMode selects which side of an anti-entropy round sends the messages the other side is missing.
+type AntiEntropyMode string
+
+const (
+	AntiEntropyPushPull AntiEntropyMode = ""     // both peers send each other the messages the other one is missing
+	AntiEntropyPull     AntiEntropyMode = "pull" // the contacted peer sends the messages the initiating peer is missing
+	AntiEntropyPush     AntiEntropyMode = "push" // the initiating peer sends the messages the contacted peer is missing
+)
+
+// AntiEntropyConfig enables periodic anti-entropy rounds, in which every peer exchanges digests of the message IDs
+// it has seen with random neighbors and the missing messages are sent over. Combined with Gossip it lets a push
+// protocol recover from stalls; combined with Pull it disseminates messages by pulling only.
+//
+// A round starts with a digest sent by the initiating peer. The contacted peer answers with the messages missing
+// from the digest in pull mode, with a digest of its own in push mode, and with both in push-pull mode; in the
+// last two modes the initiating peer then sends the messages missing from that reply. Messages sent during a round
+// are recorded as regular sends, and are forwarded by their ProtocolFunc when they are first received.
+// Digests have no size and are not delayed by bandwidth limits.
+type AntiEntropyConfig struct {
+	Interval float64         // time between two rounds of a peer, in milliseconds; must be positive
+	Fanout   int             // number of random neighbors contacted in each round; zero means 1
+	Rounds   int             // number of rounds of each peer; zero means unlimited, in which case the network never becomes quiescent
+	Mode     AntiEntropyMode // which side of a round sends missing messages
+}
+
+// antiEntropy is the per-peer handler running anti-entropy rounds.
+type antiEntropy struct {
+	cfg    *AntiEntropyConfig
+	rounds int // number of rounds started by the peer
+}
+
+// start schedules the peer's rounds, offsetting the first one by a random fraction of the interval
+// so that peers do not all start their rounds at the same instant.
+func (a *antiEntropy) start(network *P2P, p *peer) {
+	offset := network.rng.Float64() * a.cfg.Interval
+
+	network.every(p, offset, a.cfg.Interval, func() bool {
+		a.rounds++
+		a.round(network, p)
+
+		return a.cfg.Rounds == 0 || a.rounds < a.cfg.Rounds
+	})
+}
+
+// round sends the peer's digest to randomly chosen neighbors.
+func (a *antiEntropy) round(network *P2P, p *peer) {
+	neighbors := p.neighborIDs()
+
+	network.rng.Shuffle(len(neighbors), func(i, j int) {
+		neighbors[i], neighbors[j] = neighbors[j], neighbors[i]
+	})
+
+	fanout := a.cfg.Fanout
+	if fanout <= 0 {
+		fanout = 1
+	}
+	if fanout > len(neighbors) {
+		fanout = len(neighbors)
+	}
+
+	digest := p.digest()
+
+	for _, targetID := range neighbors[:fanout] {
+		p.send(network, targetID, Message{Kind: KindDigest, Digest: digest})
+	}
+}
+
+// receive answers digests according to the mode of the rounds.
+func (a *antiEntropy) receive(network *P2P, p *peer, msg Message, first bool) {
+	switch msg.Kind {
+	case KindDigest:
+		if a.cfg.Mode == AntiEntropyPull || a.cfg.Mode == AntiEntropyPushPull {
+			p.sendMissing(network, msg.From, msg.Digest)
+		}
+		if a.cfg.Mode == AntiEntropyPush || a.cfg.Mode == AntiEntropyPushPull {
+			p.send(network, msg.From, Message{Kind: KindDigestReply, Digest: p.digest()})
+		}
+	case KindDigestReply:
+		p.sendMissing(network, msg.From, msg.Digest)
+	}
+}
+
+// digest returns the sorted IDs of the messages the peer has seen.
+func (p *peer) digest() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msgIDs := make([]string, 0, len(p.messages))
+	for msgID := range p.messages {
+		msgIDs = append(msgIDs, msgID)
+	}
+
+	slices.Sort(msgIDs)
+
+	return msgIDs
+}
+
+// sendMissing sends the target every message the peer has seen whose ID is not in the target's sorted digest.
+// Each message is sent as the peer first received it, one hop further.
+func (p *peer) sendMissing(network *P2P, targetID PeerID, digest []string) {
+	for _, msgID := range p.digest() {
+		if _, found := slices.BinarySearch(digest, msgID); found {
+			continue
+		}
+
+		p.mu.Lock()
+		msg := p.messages[msgID]
+		p.mu.Unlock()
+
+		msg.HopCount++
+		msg.DynamicParams = nil
+
+		p.send(network, targetID, msg)
+	}
+}
@@ -0,0 +1,173 @@
+package p2p
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/elecbug/netkit/v2/graph"
+	"github.com/elecbug/netkit/v2/graph/standard"
+)
+
+/* Scenarios */
+
+// Eclipse rewires the network with standard.Eclipse so that all neighbors of the victim are attackers.
+// New links get their latency from Config.NetworkLatencyFunc, and messages in flight over removed links are dropped.
+func (p *P2P) Eclipse(victim PeerID, attackers []PeerID) error {
+	g, err := p.Graph()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]graph.NodeID, len(attackers))
+	for i, id := range attackers {
+		ids[i] = graph.NodeID(id)
+	}
+
+	if err := standard.Eclipse(g, nil, graph.NodeID(victim), ids); err != nil {
+		return err
+	}
+
+	return p.rewire(g)
+}
+
+// AddSybils adds Sybil peers to the network with standard.AddSybils, drawing the seed from the network's random source,
+// and returns their IDs, which skip those of existing peers so that it can be called repeatedly with the same prefix.
+// The peers are created like those added by AddPeer, so Config.BehaviorFunc can make them misbehave.
+func (p *P2P) AddSybils(config standard.SybilConfig) ([]PeerID, error) {
+	g, err := p.Graph()
+	if err != nil {
+		return nil, err
+	}
+
+	sybils, err := standard.AddSybils(int(p.rng.Int64()), g, nil, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.rewire(g); err != nil {
+		return nil, err
+	}
+
+	ids := make([]PeerID, len(sybils))
+	for i, id := range sybils {
+		ids[i] = PeerID(id)
+	}
+
+	return ids, nil
+}
+
+// rewire adds the peers of g missing from the network, then removes and creates links so that the links of every
+// peer match the edges of g.
+func (p *P2P) rewire(g *graph.Graph) error {
+	for _, id := range g.Nodes() {
+		if _, ok := p.peer(PeerID(id)); !ok {
+			if err := p.AddPeer(PeerID(id)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, n := range p.peerList() {
+		node, err := g.Node(graph.NodeID(n.id))
+		if err != nil {
+			return err
+		}
+
+		targets := make([]PeerID, 0, node.Degree())
+		for _, id := range node.Neighbors() {
+			targets = append(targets, PeerID(id))
+		}
+
+		slices.Sort(targets)
+
+		current := n.neighborIDs()
+
+		for _, id := range current {
+			if !slices.Contains(targets, id) {
+				if err := p.Disconnect(n.id, id); err != nil {
+					return fmt.Errorf("failed to disconnect %s from %s: %v", n.id, id, err)
+				}
+			}
+		}
+
+		for _, id := range targets {
+			if !slices.Contains(current, id) {
+				if err := p.Connect(n.id, id); err != nil {
+					return fmt.Errorf("failed to connect %s to %s: %v", n.id, id, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/* Report */
+
+// Isolation measures how well a set of victims was isolated from a message by the attackers.
+type Isolation struct {
+	ID          string  `json:"id"`           // ID of the message
+	Victims     int     `json:"victims"`      // number of victims
+	Reached     int     `json:"reached"`      // number of victims that received the message
+	Captured    int     `json:"captured"`     // number of reached victims whose first reception came from an attacker
+	Coverage    float64 `json:"coverage"`     // fraction of the victims that received the message
+	CaptureRate float64 `json:"capture_rate"` // fraction of the reached victims whose first reception came from an attacker
+
+	VictimLatency float64 `json:"victim_latency"` // mean first-reception latency of the reached victims, in milliseconds
+	OtherLatency  float64 `json:"other_latency"`  // mean first-reception latency of the reached peers that are neither victims, attackers nor the publisher, in milliseconds
+}
+
+// Isolation computes the reachability of the specified message among the victims, which of them first received it
+// from an attacker, and how much later they received it than the other peers.
+func (p *P2P) Isolation(msg string, victims, attackers []PeerID) (*Isolation, error) {
+	p.mu.RLock()
+	pub, ok := p.published[msg]
+	p.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("message %s was not published", msg)
+	}
+
+	for _, id := range victims {
+		if _, ok := p.peer(id); !ok {
+			return nil, fmt.Errorf("peer %s not found", id)
+		}
+	}
+
+	iso := &Isolation{ID: msg, Victims: len(victims)}
+	others := 0
+
+	for _, r := range p.FirstMessageReceptions(msg) {
+		latency := float64(r.Timestamp.Sub(pub.at)) / float64(time.Millisecond)
+
+		if !slices.Contains(victims, r.PeerID) {
+			if r.PeerID != pub.publisher && !slices.Contains(attackers, r.PeerID) {
+				iso.OtherLatency += latency
+				others++
+			}
+
+			continue
+		}
+
+		iso.Reached++
+		iso.VictimLatency += latency
+
+		if slices.Contains(attackers, r.From) {
+			iso.Captured++
+		}
+	}
+
+	if iso.Victims > 0 {
+		iso.Coverage = float64(iso.Reached) / float64(iso.Victims)
+	}
+	if iso.Reached > 0 {
+		iso.CaptureRate = float64(iso.Captured) / float64(iso.Reached)
+		iso.VictimLatency /= float64(iso.Reached)
+	}
+	if others > 0 {
+		iso.OtherLatency /= float64(others)
+	}
+
+	return iso, nil
+}
@@ -0,0 +1,115 @@
+package p2p
+
+import (
+	"time"
+)
+
+// transmit sends a message over the edge, modeling serialization delay and per-link queueing.
+// The sender's upload link transmits one message at a time, in the order the protocol chose the targets,
+// at the smaller of its upload bandwidth and the link bandwidth. The first bit reaches the target after the
+// link's network latency, and the target's download link receives one message at a time at its download
+// bandwidth, so that messages arriving while it is busy wait for it. The caller must hold the peer's mutex.
+func (p *peer) transmit(network *P2P, e edge, msg Message) {
+	now := network.now()
+
+	rate := p.uploadBandwidth
+	if e.bandwidth > 0 && (rate <= 0 || e.bandwidth < rate) {
+		rate = e.bandwidth
+	}
+
+	txUp := transmissionTime(msg.Size, rate)
+
+	start := now
+	if p.uploadFreeAt.After(start) {
+		start = p.uploadFreeAt
+	}
+
+	p.uploadFreeAt = start.Add(milliseconds(txUp))
+
+	firstBit := start.Add(milliseconds(e.networkLatency))
+	lastBit := firstBit.Add(milliseconds(txUp))
+
+	network.afterDuration(firstBit.Sub(now), func() {
+		if network.transport != nil {
+			network.transport.send(p.id, e.targetID, msg, lastBit)
+			return
+		}
+
+		network.arrive(p, e.targetID, msg, lastBit)
+	})
+}
+
+// arrive receives a message whose first bit reaches the target now on the target's download link,
+// and delivers it once completely received unless the target or the link has failed in the meantime.
+func (p *P2P) arrive(sender *peer, targetID PeerID, msg Message, lastBit time.Time) {
+	target, ok := p.peer(targetID)
+	if !ok || target == nil {
+		return
+	}
+
+	deliver := func() {
+		if !target.isAlive() || !sender.linkUp(targetID) {
+			return
+		}
+
+		p.deliver(target, msg)
+	}
+
+	arrival := p.now()
+	deliveredAt := target.reserveDownload(arrival, lastBit, msg.Size)
+
+	if deliveredAt.After(arrival) {
+		p.afterDuration(deliveredAt.Sub(arrival), deliver)
+	} else {
+		deliver()
+	}
+}
+
+// reserveDownload queues a message whose first bit arrives now and whose last bit leaves the sender's link at lastBit
+// on the peer's download link, and returns the time at which the message is completely received.
+func (p *peer) reserveDownload(firstBit, lastBit time.Time, size int) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	txDown := transmissionTime(size, p.downloadBandwidth)
+
+	begin := firstBit
+	if p.downloadFreeAt.After(begin) {
+		begin = p.downloadFreeAt
+	}
+
+	deliveredAt := begin.Add(milliseconds(txDown))
+	if lastBit.After(deliveredAt) {
+		deliveredAt = lastBit
+	}
+
+	if txDown > 0 {
+		p.downloadFreeAt = deliveredAt
+	}
+
+	return deliveredAt
+}
+
+// transmissionTime returns the time in milliseconds needed to transmit size bytes at the given bandwidth
+// in megabits per second, or zero if the bandwidth is unlimited.
+func transmissionTime(size int, mbps float64) float64 {
+	if size <= 0 || mbps <= 0 {
+		return 0
+	}
+
+	return float64(size) * 8 / (mbps * 1000)
+}
+
+// bandwidthOf returns the bandwidth generated by f for the peer, or zero (unlimited) if f is nil.
+func bandwidthOf(f func(id PeerID) float64, id PeerID) float64 {
+	if f == nil {
+		return 0
+	}
+
+	return f(id)
+}
+
+// milliseconds converts a duration in milliseconds to a time.Duration.
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
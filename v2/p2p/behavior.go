@@ -0,0 +1,160 @@
+package p2p
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Behavior decides how a misbehaving peer handles each message it is about to send to a neighbor, whether chosen by a
+// ProtocolFunc or by a built-in protocol. It returns the message to send instead, which may carry a modified content,
+// an extra delay in milliseconds, and false to drop the message. Set it per peer with Config.BehaviorFunc.
+type Behavior func(id PeerID, target PeerID, msg Message) (Message, float64, bool)
+
+// Silent drops every message, so that the peer receives messages but never forwards or answers anything.
+var Silent Behavior = func(id PeerID, target PeerID, msg Message) (Message, float64, bool) {
+	return msg, 0, false
+}
+
+// Equivocating forwards a different version of every message to each target under the same message ID, whose content
+// is the original content, up to its first "@", followed by "@" and the target ID, so that honest peers receive
+// conflicting messages and keep whichever version reaches them first.
+var Equivocating Behavior = func(id PeerID, target PeerID, msg Message) (Message, float64, bool) {
+	if msg.Kind == KindPayload {
+		original, _, _ := strings.Cut(msg.Content, "@")
+		msg.Content = fmt.Sprintf("%s@%s", original, target)
+	}
+
+	return msg, 0, true
+}
+
+// Delaying returns a behavior that holds every message for the given extra delay in milliseconds before sending it.
+func Delaying(delay float64) Behavior {
+	return func(id PeerID, target PeerID, msg Message) (Message, float64, bool) {
+		return msg, delay, true
+	}
+}
+
+// Selective returns a behavior that sends every message with the given probability, drawn from the network's
+// seeded random source, and drops it otherwise.
+func Selective(probability float64) Behavior {
+	return func(id PeerID, target PeerID, msg Message) (Message, float64, bool) {
+		return msg, 0, msg.Rand.Float64() < probability
+	}
+}
+
+// RandomBehaviors returns a function for Config.BehaviorFunc that assigns the behavior to the given fraction of the peers,
+// rounded down and chosen at random with the seed, and leaves the other peers honest. The fraction is clamped to [0, 1].
+func RandomBehaviors(ids []PeerID, fraction float64, behavior Behavior, seed uint64) func(id PeerID) Behavior {
+	shuffled := slices.Clone(ids)
+	slices.Sort(shuffled)
+
+	rng := newRand(seed)
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	fraction = min(max(fraction, 0), 1)
+
+	chosen := make(map[PeerID]struct{})
+	for _, id := range shuffled[:int(fraction*float64(len(shuffled)))] {
+		chosen[id] = struct{}{}
+	}
+
+	return func(id PeerID) Behavior {
+		if _, ok := chosen[id]; ok {
+			return behavior
+		}
+
+		return nil
+	}
+}
+
+// behaviorOf returns the behavior generated by f for the peer, or nil (honest) if f is nil.
+func behaviorOf(f func(id PeerID) Behavior, id PeerID) Behavior {
+	if f == nil {
+		return nil
+	}
+
+	return f(id)
+}
+
+/* Report */
+
+// BehaviorReport summarizes how a message propagated among the honest peers of a network with misbehaving peers.
+// Latencies are measured in milliseconds from the publish time to the first reception at each honest peer other than the publisher.
+type BehaviorReport struct {
+	ID          string   `json:"id"`          // ID of the message
+	Misbehaving []PeerID `json:"misbehaving"` // sorted IDs of the peers with a behavior
+	Honest      int      `json:"honest"`      // number of honest peers
+	Reached     int      `json:"reached"`     // number of honest peers that received the message, including an honest publisher
+	Coverage    float64  `json:"coverage"`    // fraction of honest peers that received the message
+
+	LatencyP50 float64 `json:"latency_p50"` // median first-reception latency of honest peers, in milliseconds
+	LatencyP90 float64 `json:"latency_p90"` // 90th percentile first-reception latency of honest peers, in milliseconds
+	LatencyMax float64 `json:"latency_max"` // maximum first-reception latency of honest peers, in milliseconds
+}
+
+// Misbehaving returns the sorted IDs of the peers that were given a behavior by Config.BehaviorFunc.
+func (p *P2P) Misbehaving() []PeerID {
+	ids := make([]PeerID, 0)
+
+	for _, n := range p.peerList() {
+		if n.behavior != nil {
+			ids = append(ids, n.id)
+		}
+	}
+
+	return ids
+}
+
+// BehaviorReport computes the reachability and latency of the specified message among honest peers,
+// so that runs with different fractions of misbehaving peers can be compared.
+func (p *P2P) BehaviorReport(msg string) (*BehaviorReport, error) {
+	p.mu.RLock()
+	pub, ok := p.published[msg]
+	p.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("message %s was not published", msg)
+	}
+
+	report := &BehaviorReport{ID: msg, Misbehaving: make([]PeerID, 0)}
+	latencies := make([]float64, 0)
+
+	for _, n := range p.peerList() {
+		if n.behavior != nil {
+			report.Misbehaving = append(report.Misbehaving, n.id)
+			continue
+		}
+
+		report.Honest++
+
+		n.mu.Lock()
+		t, seen := n.seenAt[msg]
+		n.mu.Unlock()
+
+		if !seen {
+			continue
+		}
+
+		report.Reached++
+
+		if n.id != pub.publisher {
+			latencies = append(latencies, float64(t.Sub(pub.at))/float64(time.Millisecond))
+		}
+	}
+
+	slices.Sort(latencies)
+
+	if report.Honest > 0 {
+		report.Coverage = float64(report.Reached) / float64(report.Honest)
+	}
+
+	report.LatencyP50 = Percentile(latencies, 0.50)
+	report.LatencyP90 = Percentile(latencies, 0.90)
+	report.LatencyMax = Percentile(latencies, 1)
+
+	return report, nil
+}
@@ -0,0 +1,299 @@
+package p2p
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Trigger describes a reception event that fires a scheduled failure or recovery.
+// A trigger fires once, at the first reception of the message that matches all of its set fields.
+type Trigger struct {
+	ID   string // ID of the message whose reception fires the trigger
+	Peer PeerID // peer whose reception fires the trigger; empty matches any peer
+	Hop  int    // minimum hop count of the reception; zero matches any hop
+}
+
+// ChurnModel describes peer churn where session lengths and downtimes are exponentially distributed.
+type ChurnModel struct {
+	MeanSession  float64  // mean time a peer stays online before crashing, in milliseconds
+	MeanDowntime float64  // mean time a crashed peer stays offline before recovering, in milliseconds
+	Duration     float64  // time after which no more crashes are scheduled, in milliseconds, which must be positive; offline peers still recover
+	Peers        []PeerID // peers subject to churn; nil means all peers
+}
+
+// trigger is a pending action fired by the first matching reception event.
+type trigger struct {
+	cond   Trigger
+	action func()
+}
+
+// matches reports whether the first reception of msg at peer id satisfies the trigger condition.
+func (t *trigger) matches(id PeerID, msg Message) bool {
+	if t.cond.ID != msg.ID {
+		return false
+	}
+	if t.cond.Peer != "" && t.cond.Peer != id {
+		return false
+	}
+
+	return msg.HopCount >= t.cond.Hop
+}
+
+/* Peer Failures */
+
+// Crash marks the peer as failed. A crashed peer drops every message it receives and stops forwarding,
+// but keeps the messages it has already seen. The stateful protocols of its neighbors are notified.
+func (p *P2P) Crash(id PeerID) error {
+	peer, ok := p.peer(id)
+	if !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	peer.mu.Lock()
+	wasAlive := peer.alive
+	peer.alive = false
+	peer.mu.Unlock()
+
+	if wasAlive {
+		p.notifyPeerDown(id)
+	}
+
+	return nil
+}
+
+// Recover brings a crashed peer back online with the state it had before crashing.
+// It returns an error if the peer has been stopped by ExpireSimulation or Free.
+func (p *P2P) Recover(id PeerID) error {
+	peer, ok := p.peer(id)
+	if !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if peer.stopped {
+		return fmt.Errorf("peer %s has been stopped", id)
+	}
+
+	peer.alive = true
+
+	return nil
+}
+
+// ScheduleCrash crashes the peer after the given delay in milliseconds.
+func (p *P2P) ScheduleCrash(id PeerID, after float64) error {
+	if _, ok := p.peer(id); !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	p.after(after, func() { p.Crash(id) })
+
+	return nil
+}
+
+// ScheduleRecover recovers the peer after the given delay in milliseconds.
+func (p *P2P) ScheduleRecover(id PeerID, after float64) error {
+	if _, ok := p.peer(id); !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	p.after(after, func() { p.Recover(id) })
+
+	return nil
+}
+
+// CrashOn crashes the peer as soon as a reception matching the trigger occurs.
+// If the trigger matches a reception at the crashing peer itself, the peer crashes before forwarding the message.
+func (p *P2P) CrashOn(id PeerID, cond Trigger) error {
+	if _, ok := p.peer(id); !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	p.addTrigger(cond, func() { p.Crash(id) })
+
+	return nil
+}
+
+// RecoverOn recovers the peer as soon as a reception matching the trigger occurs.
+func (p *P2P) RecoverOn(id PeerID, cond Trigger) error {
+	if _, ok := p.peer(id); !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	p.addTrigger(cond, func() { p.Recover(id) })
+
+	return nil
+}
+
+/* Link Failures */
+
+// FailLink marks the link between two peers as failed in both directions.
+// Messages sent over a failed link, including those already in flight, are lost.
+func (p *P2P) FailLink(a, b PeerID) error {
+	return p.setLinkDown(a, b, true)
+}
+
+// RestoreLink restores a failed link between two peers.
+func (p *P2P) RestoreLink(a, b PeerID) error {
+	return p.setLinkDown(a, b, false)
+}
+
+// ScheduleLinkFailure fails the link between two peers after the given delay in milliseconds.
+func (p *P2P) ScheduleLinkFailure(a, b PeerID, after float64) error {
+	if err := p.checkLink(a, b); err != nil {
+		return err
+	}
+
+	p.after(after, func() { p.FailLink(a, b) })
+
+	return nil
+}
+
+// ScheduleLinkRecovery restores the link between two peers after the given delay in milliseconds.
+func (p *P2P) ScheduleLinkRecovery(a, b PeerID, after float64) error {
+	if err := p.checkLink(a, b); err != nil {
+		return err
+	}
+
+	p.after(after, func() { p.RestoreLink(a, b) })
+
+	return nil
+}
+
+// FailLinkOn fails the link between two peers as soon as a reception matching the trigger occurs.
+func (p *P2P) FailLinkOn(a, b PeerID, cond Trigger) error {
+	if err := p.checkLink(a, b); err != nil {
+		return err
+	}
+
+	p.addTrigger(cond, func() { p.FailLink(a, b) })
+
+	return nil
+}
+
+/* Churn */
+
+// StartChurn schedules alternating crashes and recoveries for the selected peers, drawing exponentially
+// distributed session lengths and downtimes from the network's seeded random source.
+// It returns an error if the means or the duration are not positive, or if a peer does not exist.
+func (p *P2P) StartChurn(model ChurnModel) error {
+	if model.MeanSession <= 0 || model.MeanDowntime <= 0 {
+		return fmt.Errorf("mean session and mean downtime must be positive")
+	}
+	if model.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+
+	ids := model.Peers
+	if ids == nil {
+		ids = p.PeerIDs()
+	} else {
+		ids = slices.Clone(ids)
+		slices.Sort(ids)
+	}
+
+	for _, id := range ids {
+		if _, ok := p.peer(id); !ok {
+			return fmt.Errorf("peer %s not found", id)
+		}
+	}
+
+	for _, id := range ids {
+		p.churn(id, model, 0)
+	}
+
+	return nil
+}
+
+// churn schedules the next session of a peer starting at the given elapsed churn time.
+func (p *P2P) churn(id PeerID, model ChurnModel, elapsed float64) {
+	session := p.rng.ExpFloat64() * model.MeanSession
+	if elapsed+session > model.Duration {
+		return
+	}
+
+	downtime := p.rng.ExpFloat64() * model.MeanDowntime
+
+	p.after(session, func() {
+		p.Crash(id)
+
+		p.after(downtime, func() {
+			p.Recover(id)
+			p.churn(id, model, elapsed+session+downtime)
+		})
+	})
+}
+
+/* Utility Functions */
+
+// addTrigger registers an action to be fired by the first reception matching the condition.
+func (p *P2P) addTrigger(cond Trigger, action func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.triggers = append(p.triggers, &trigger{cond: cond, action: action})
+}
+
+// fireTriggers runs and removes every pending trigger matched by the first reception of msg at peer id.
+func (p *P2P) fireTriggers(id PeerID, msg Message) {
+	p.mu.Lock()
+
+	fired := make([]*trigger, 0)
+	remaining := p.triggers[:0]
+
+	for _, t := range p.triggers {
+		if t.matches(id, msg) {
+			fired = append(fired, t)
+		} else {
+			remaining = append(remaining, t)
+		}
+	}
+
+	p.triggers = remaining
+	p.mu.Unlock()
+
+	for _, t := range fired {
+		t.action()
+	}
+}
+
+// checkLink returns an error if the two peers are not connected.
+func (p *P2P) checkLink(a, b PeerID) error {
+	peerA, ok := p.peer(a)
+	if !ok {
+		return fmt.Errorf("peer %s not found", a)
+	}
+	if _, ok := p.peer(b); !ok {
+		return fmt.Errorf("peer %s not found", b)
+	}
+
+	peerA.mu.Lock()
+	defer peerA.mu.Unlock()
+
+	if _, ok := peerA.edges[b]; !ok {
+		return fmt.Errorf("no link between %s and %s", a, b)
+	}
+
+	return nil
+}
+
+// setLinkDown sets the failure state of the link between two peers in both directions.
+func (p *P2P) setLinkDown(a, b PeerID, down bool) error {
+	if err := p.checkLink(a, b); err != nil {
+		return err
+	}
+
+	for _, pair := range [][2]PeerID{{a, b}, {b, a}} {
+		peer, _ := p.peer(pair[0])
+
+		peer.mu.Lock()
+		if e, ok := peer.edges[pair[1]]; ok {
+			e.down = down
+			peer.edges[pair[1]] = e
+		}
+		peer.mu.Unlock()
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// DandelionConfig enables Dandelion++ propagation for messages published with Message.Stem set. In the stem phase,
+// a message is relayed along a random path: every peer forwards it to one of its Relays randomly chosen neighbors,
+// always the same one for messages coming from the same neighbor, until it reaches a peer that is a diffuser for the
+// current epoch, which starts the fluff phase by forwarding it with its ProtocolFunc, or Flooding if it has none.
+// A peer is a diffuser with probability FluffProbability, and the roles and relays are drawn again at every epoch.
+//
+// A stem message that loops back to a peer that already relayed it is fluffed there, and a stem peer forwards the
+// fluffed message when it first receives it, although it has already seen its stem. A stem peer that does not see
+// the message fluffed within EmbargoTimeout, plus an exponentially distributed delay of the same mean, fluffs it
+// itself, which prevents messages from being lost at crashed relays.
+type DandelionConfig struct {
+	FluffProbability float64 // probability that a peer is a diffuser in an epoch; zero means 0.1
+	Relays           int     // number of stem relays of a peer, 1 for the line graph of Dandelion and 2 for Dandelion++; zero means 2
+	EpochLength      float64 // time between two draws of the roles and relays, in milliseconds; zero keeps them for the whole run
+	Epochs           int     // number of epochs after the first when EpochLength is set; zero means unlimited, in which case the network never becomes quiescent and must be run with RunFor
+	EmbargoTimeout   float64 // minimum time a stem peer waits for the message to be fluffed, in milliseconds; zero means 1000
+}
+
+// dandelion is the per-peer handler of Dandelion++.
+type dandelion struct {
+	cfg *DandelionConfig
+
+	diffuser bool              // indicates whether the peer fluffs the stem messages it receives in this epoch
+	relays   []PeerID          // stem relays of the peer in this epoch
+	epochs   int               // number of epochs drawn after the first
+	routes   map[PeerID]PeerID // neighbor -> relay stem messages from that neighbor are forwarded to
+	fluffed  map[string]bool   // message ID -> whether the peer has seen the message in the fluff phase
+	mu       sync.Mutex        // mutex to protect access to the handler's state
+}
+
+// newDandelion creates a Dandelion++ handler.
+func newDandelion(cfg *DandelionConfig) *dandelion {
+	return &dandelion{
+		cfg:     cfg,
+		routes:  make(map[PeerID]PeerID),
+		fluffed: make(map[string]bool),
+	}
+}
+
+// start draws the roles and relays of the first epoch and schedules the next epochs.
+func (d *dandelion) start(network *P2P, p *peer) {
+	d.epoch(network, p)
+
+	if d.cfg.EpochLength > 0 {
+		network.every(p, d.cfg.EpochLength, d.cfg.EpochLength, func() bool {
+			d.epochs++
+			d.epoch(network, p)
+
+			return d.cfg.Epochs == 0 || d.epochs < d.cfg.Epochs
+		})
+	}
+}
+
+// epoch draws whether the peer is a diffuser and its stem relays.
+func (d *dandelion) epoch(network *P2P, p *peer) {
+	probability := d.cfg.FluffProbability
+	if probability == 0 {
+		probability = 0.1
+	}
+
+	count := d.cfg.Relays
+	if count == 0 {
+		count = 2
+	}
+
+	neighbors := p.neighborIDs()
+	network.rng.Shuffle(len(neighbors), func(i, j int) {
+		neighbors[i], neighbors[j] = neighbors[j], neighbors[i]
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.diffuser = network.rng.Float64() < probability
+	d.relays = neighbors[:min(count, len(neighbors))]
+	d.routes = make(map[PeerID]PeerID)
+}
+
+// receive relays stem messages and records the messages seen in the fluff phase.
+func (d *dandelion) receive(network *P2P, p *peer, msg Message, first bool) {
+	if msg.Kind != KindPayload {
+		return
+	}
+
+	if !msg.Stem {
+		d.mu.Lock()
+		forward := !first && !d.fluffed[msg.ID]
+		d.fluffed[msg.ID] = true
+		d.mu.Unlock()
+
+		// a stem peer receives the fluffed message as a duplicate, which is not forwarded by its protocol otherwise
+		if forward && !hopLimited(msg) {
+			network.after(p.processingLatency, func() {
+				p.eachPublish(network, msg)
+			})
+		}
+
+		return
+	}
+
+	if !first {
+		// the stem looped back to a peer that already relayed it
+		network.after(p.processingLatency, func() {
+			d.fluff(network, p, msg)
+		})
+
+		return
+	}
+
+	d.mu.Lock()
+
+	relay, ok := d.routes[msg.From]
+	if !ok && len(d.relays) > 0 {
+		relay = d.relays[network.rng.IntN(len(d.relays))]
+		d.routes[msg.From] = relay
+	}
+
+	// a peer's own messages always enter the stem, so that its first relay cannot tell it apart from a relaying peer
+	fluff := (d.diffuser && msg.From != p.id) || len(d.relays) == 0
+
+	d.mu.Unlock()
+
+	network.after(p.processingLatency, func() {
+		if fluff {
+			d.fluff(network, p, msg)
+			return
+		}
+
+		out := msg
+		out.HopCount++
+		out.DynamicParams = nil
+
+		p.send(network, relay, out)
+	})
+
+	embargo := d.cfg.EmbargoTimeout
+	if embargo == 0 {
+		embargo = 1000
+	}
+
+	network.after(embargo+network.rng.ExpFloat64()*embargo, func() {
+		d.fluff(network, p, msg)
+	})
+}
+
+// fluff starts the fluff phase of a stem message at the peer, unless the peer has already seen it fluffed.
+func (d *dandelion) fluff(network *P2P, p *peer, msg Message) {
+	d.mu.Lock()
+	if d.fluffed[msg.ID] {
+		d.mu.Unlock()
+		return
+	}
+	d.fluffed[msg.ID] = true
+	d.mu.Unlock()
+
+	msg.Stem = false
+	if msg.Protocol == nil {
+		msg.Protocol = Flooding
+	}
+
+	p.eachPublish(network, msg)
+}
+
+// StemRelays returns the sorted IDs of the current Dandelion++ stem relays of the peer.
+// It returns an error if the peer does not exist or Dandelion++ is not enabled.
+func (p *P2P) StemRelays(id PeerID) ([]PeerID, error) {
+	n, ok := p.peer(id)
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", id)
+	}
+
+	for _, h := range n.handlers {
+		if d, ok := h.(*dandelion); ok {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			relays := slices.Clone(d.relays)
+			slices.Sort(relays)
+
+			return relays, nil
+		}
+	}
+
+	return nil, fmt.Errorf("dandelion is not enabled")
+}
@@ -0,0 +1,184 @@
+package experiment
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Estimate summarizes the values of a metric over the repetitions of a combination.
+type Estimate struct {
+	Mean   float64 `json:"mean"`    // sample mean
+	StdDev float64 `json:"stddev"`  // sample standard deviation; zero with a single repetition
+	CILow  float64 `json:"ci_low"`  // lower bound of the Student t confidence interval of the mean
+	CIHigh float64 `json:"ci_high"` // upper bound of the Student t confidence interval of the mean
+}
+
+// estimate computes the mean, standard deviation and confidence interval of the values.
+// With a single value, the interval collapses to the value.
+func estimate(values []float64, confidence float64) Estimate {
+	n := float64(len(values))
+	if n == 0 {
+		return Estimate{}
+	}
+
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= n
+
+	if len(values) == 1 {
+		return Estimate{Mean: mean, CILow: mean, CIHigh: mean}
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= n - 1
+
+	stddev := math.Sqrt(variance)
+	margin := studentQuantile(1-(1-confidence)/2, n-1) * stddev / math.Sqrt(n)
+
+	return Estimate{Mean: mean, StdDev: stddev, CILow: mean - margin, CIHigh: mean + margin}
+}
+
+// studentQuantile returns the quantile q of the Student t distribution with df degrees of freedom, for q above 0.5,
+// by bisection over its cumulative distribution.
+func studentQuantile(q, df float64) float64 {
+	low, high := 0.0, 1.0
+	for studentCDF(high, df) < q {
+		high *= 2
+	}
+
+	for range 100 {
+		mid := (low + high) / 2
+		if studentCDF(mid, df) < q {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return (low + high) / 2
+}
+
+// studentCDF returns the cumulative distribution of the Student t distribution with df degrees of freedom at t >= 0.
+func studentCDF(t, df float64) float64 {
+	return 1 - regularizedBeta(df/(df+t*t), df/2, 0.5)/2
+}
+
+// regularizedBeta returns the regularized incomplete beta function I_x(a, b), evaluated by its continued fraction.
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	if x > (a+1)/(a+b+2) {
+		return 1 - regularizedBeta(1-x, b, a)
+	}
+
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab-la-lb+a*math.Log(x)+b*math.Log(1-x)) / a
+
+	// modified Lentz's method
+	const tiny = 1e-300
+
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+
+	for m := 1.0; m <= 300; m++ {
+		for _, numerator := range []float64{
+			m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)),
+			-(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1)),
+		} {
+			d = 1 + numerator*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + numerator/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			f *= c * d
+		}
+
+		if math.Abs(c*d-1) < 1e-15 {
+			break
+		}
+	}
+
+	return front * f
+}
+
+/* Export */
+
+// WriteCSV writes the results as CSV, one row per combination with the mean, standard deviation and confidence
+// interval of every metric. Graph and protocol parameters are written as sorted key=value pairs separated by semicolons.
+func WriteCSV(w io.Writer, results []*Result) error {
+	metrics := []string{"coverage", "latency_p50", "latency_p90", "latency_max", "sent", "redundancy"}
+
+	header := []string{"graph", "graph_params", "protocol", "params", "runs"}
+	for _, metric := range metrics {
+		header = append(header, metric+"_mean", metric+"_stddev", metric+"_ci_low", metric+"_ci_high")
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %v", err)
+	}
+
+	for _, r := range results {
+		record := []string{
+			string(r.Graph.Type),
+			formatParams(r.Graph.Params),
+			r.Protocol,
+			formatParams(r.Params),
+			strconv.Itoa(len(r.Runs)),
+		}
+
+		for _, e := range []Estimate{r.Coverage, r.LatencyP50, r.LatencyP90, r.LatencyMax, r.Sent, r.Redundancy} {
+			for _, v := range []float64{e.Mean, e.StdDev, e.CILow, e.CIHigh} {
+				record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV record: %v", err)
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// formatParams formats parameters as key=value pairs sorted by key and separated by semicolons.
+func formatParams(params map[string]any) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", key, params[key])
+	}
+
+	return strings.Join(pairs, ";")
+}
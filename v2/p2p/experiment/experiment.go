@@ -0,0 +1,229 @@
+// Package experiment runs Monte Carlo experiments over P2P simulations: every combination of a grid of topologies,
+// protocols and protocol parameters is simulated for a number of repetitions with independent seeds, in parallel,
+// and the propagation metrics of each combination are summarized by their mean and confidence interval.
+//
+// Repetitions use the same seeds in every combination, so that protocols are compared over the same topologies
+// and publishers.
+package experiment
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/elecbug/netkit/v2/graph/standard"
+	"github.com/elecbug/netkit/v2/p2p"
+)
+
+// Protocol is a named protocol to compare.
+type Protocol struct {
+	Name string           // name of the protocol in the results
+	Func p2p.ProtocolFunc // protocol function used to publish the message
+}
+
+// Config holds the grid of an experiment.
+type Config struct {
+	Graphs      []standard.GraphConfig // topologies to generate
+	Protocols   []Protocol             // protocols to compare
+	Params      []map[string]any       // static parameters passed to the protocols; nil runs every protocol once without parameters
+	Repetitions int                    // number of repetitions of every combination
+	Parallelism int                    // number of simulations run at the same time; zero means the number of CPUs
+	Confidence  float64                // confidence level of the intervals; zero means 0.95
+	Network     *p2p.Config            // configuration of every network, whose latency functions are required and must be safe for concurrent use; the clock, transport, seed and trace writer are set by the runner
+	Seed        int                    // seed of the first repetition; repetition r uses Seed + r
+}
+
+// Run is the outcome of a single simulation.
+type Run struct {
+	Seed      int        // seed of the repetition
+	Publisher p2p.PeerID // peer that published the message
+	Stats     *p2p.MessageStats
+}
+
+// Result summarizes the repetitions of one combination of the grid.
+type Result struct {
+	Graph    standard.GraphConfig // topology of the combination
+	Protocol string               // name of the protocol
+	Params   map[string]any       // static parameters of the protocol
+	Runs     []Run                // repetitions, in seed order
+
+	Coverage   Estimate // fraction of peers reached
+	LatencyP50 Estimate // median first-reception latency, in milliseconds
+	LatencyP90 Estimate // 90th percentile first-reception latency, in milliseconds
+	LatencyMax Estimate // maximum first-reception latency, in milliseconds
+	Sent       Estimate // number of times the message was sent over a link
+	Redundancy Estimate // relative message redundancy
+}
+
+// job is a single simulation of the grid.
+type job struct {
+	cell int // index of the combination
+	rep  int // index of the repetition
+}
+
+// Execute runs every combination of the grid and returns their results, ordered by graph, then protocol, then parameters.
+// It returns an error if the configuration is invalid, if the networks would never become quiescent because a
+// built-in protocol acts on unbounded periodic timers, or if a topology cannot be generated.
+func Execute(cfg *Config) ([]*Result, error) {
+	if cfg.Network == nil || cfg.Network.NetworkLatencyFunc == nil || cfg.Network.ProcessingLatencyFunc == nil {
+		return nil, fmt.Errorf("network latency functions are required")
+	}
+	if len(cfg.Graphs) == 0 || len(cfg.Protocols) == 0 {
+		return nil, fmt.Errorf("at least one graph and one protocol are required")
+	}
+	if cfg.Repetitions <= 0 {
+		return nil, fmt.Errorf("number of repetitions must be positive")
+	}
+	if cfg.Confidence < 0 || cfg.Confidence >= 1 {
+		return nil, fmt.Errorf("confidence must be between 0 and 1")
+	}
+	// every repetition runs until the network is quiescent, which never happens with unbounded periodic timers
+	if err := cfg.Network.CheckQuiescence(); err != nil {
+		return nil, fmt.Errorf("network never becomes quiescent: %v", err)
+	}
+
+	params := cfg.Params
+	if len(params) == 0 {
+		params = []map[string]any{nil}
+	}
+
+	results := make([]*Result, 0, len(cfg.Graphs)*len(cfg.Protocols)*len(params))
+	funcs := make([]p2p.ProtocolFunc, 0, cap(results))
+	for _, gc := range cfg.Graphs {
+		for _, protocol := range cfg.Protocols {
+			for _, p := range params {
+				results = append(results, &Result{
+					Graph:    gc,
+					Protocol: protocol.Name,
+					Params:   p,
+					Runs:     make([]Run, cfg.Repetitions),
+				})
+				funcs = append(funcs, protocol.Func)
+			}
+		}
+	}
+
+	parallelism := cfg.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	jobs := make(chan job)
+	errs := make([]error, len(results)*cfg.Repetitions)
+	wg := &sync.WaitGroup{}
+
+	for range parallelism {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				run, err := simulate(cfg, results[j.cell], funcs[j.cell], cfg.Seed+j.rep)
+				if err != nil {
+					errs[j.cell*cfg.Repetitions+j.rep] = err
+					continue
+				}
+
+				results[j.cell].Runs[j.rep] = run
+			}
+		}()
+	}
+
+	for cell := range results {
+		for rep := 0; rep < cfg.Repetitions; rep++ {
+			jobs <- job{cell: cell, rep: rep}
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	confidence := cfg.Confidence
+	if confidence == 0 {
+		confidence = 0.95
+	}
+
+	for _, r := range results {
+		r.summarize(confidence)
+	}
+
+	return results, nil
+}
+
+// simulate generates the topology of the result with the seed, publishes a message from a random peer
+// with the protocol, and runs the network on a simulated clock until it is quiescent.
+func simulate(cfg *Config, r *Result, protocol p2p.ProtocolFunc, seed int) (Run, error) {
+	// the standard generators treat the seed 42 as a request for an unseeded graph, which would not be reproducible
+	graphSeed := seed
+	if graphSeed >= 42 {
+		graphSeed++
+	}
+
+	g, err := standard.StandardGraph(graphSeed, false, nil, r.Graph)
+	if err != nil {
+		return Run{}, fmt.Errorf("failed to generate %s graph: %v", r.Graph.Type, err)
+	}
+
+	nodes := g.Nodes()
+	if len(nodes) == 0 {
+		return Run{}, fmt.Errorf("%s graph has no nodes", r.Graph.Type)
+	}
+
+	network := *cfg.Network
+	network.SimulatedClock = true
+	network.Transport = p2p.TransportMemory
+	network.Seed = uint64(seed)
+	network.TraceWriter = nil
+
+	nw, err := p2p.New(g, &network)
+	if err != nil {
+		return Run{}, err
+	}
+	defer nw.Free()
+
+	nw.Run(context.Background())
+
+	rng := p2p.NewRand(uint64(seed))
+	publisher := p2p.PeerID(nodes[rng.IntN(len(nodes))])
+
+	if err := nw.Publish(publisher, "msg", protocol, r.Params, nil); err != nil {
+		return Run{}, err
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		return Run{}, err
+	}
+
+	stats, err := nw.Stats("msg")
+	if err != nil {
+		return Run{}, err
+	}
+
+	return Run{Seed: seed, Publisher: publisher, Stats: stats}, nil
+}
+
+// summarize computes the estimates of the result from its runs.
+func (r *Result) summarize(confidence float64) {
+	metric := func(f func(s *p2p.MessageStats) float64) Estimate {
+		values := make([]float64, len(r.Runs))
+		for i, run := range r.Runs {
+			values[i] = f(run.Stats)
+		}
+
+		return estimate(values, confidence)
+	}
+
+	r.Coverage = metric(func(s *p2p.MessageStats) float64 { return s.Coverage })
+	r.LatencyP50 = metric(func(s *p2p.MessageStats) float64 { return s.LatencyP50 })
+	r.LatencyP90 = metric(func(s *p2p.MessageStats) float64 { return s.LatencyP90 })
+	r.LatencyMax = metric(func(s *p2p.MessageStats) float64 { return s.LatencyMax })
+	r.Sent = metric(func(s *p2p.MessageStats) float64 { return float64(s.Sent) })
+	r.Redundancy = metric(func(s *p2p.MessageStats) float64 { return s.Redundancy })
+}
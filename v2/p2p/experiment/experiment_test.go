@@ -0,0 +1,135 @@
+package experiment_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/elecbug/netkit/v2/graph/standard"
+	"github.com/elecbug/netkit/v2/p2p"
+	"github.com/elecbug/netkit/v2/p2p/experiment"
+)
+
+// TestExperiment verifies that a grid of topologies, protocols and parameters is run with reproducible seeds
+// whatever the parallelism, that the confidence intervals enclose the means, and the CSV export.
+func TestExperiment(t *testing.T) {
+	fmt.Println("Test Experiment")
+
+	newConfig := func(parallelism int) *experiment.Config {
+		return &experiment.Config{
+			Graphs: []standard.GraphConfig{
+				{Type: standard.BarabasiAlbert, Params: map[string]any{"n": 50, "m": 2}},
+				{Type: standard.WattsStrogatz, Params: map[string]any{"n": 50, "k": 4, "beta": 0.1}},
+			},
+			Protocols: []experiment.Protocol{
+				{Name: "flooding", Func: p2p.Flooding},
+				{Name: "gossip", Func: p2p.Gossip},
+			},
+			Params: []map[string]any{
+				{"gossip_factor": 0.5},
+				{"gossip_factor": 1.0},
+			},
+			Repetitions: 5,
+			Parallelism: parallelism,
+			Network: &p2p.Config{
+				ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+				NetworkLatencyFunc:    p2p.UniformLatency(10, 50, 3),
+			},
+			Seed: 40,
+		}
+	}
+
+	fmt.Println("- Test grid")
+
+	results, err := experiment.Execute(newConfig(4))
+	if err != nil {
+		t.Fatalf("failed to run experiment: %v", err)
+	}
+	if len(results) != 8 {
+		t.Fatalf("expected 8 combinations, got %d", len(results))
+	}
+
+	for _, r := range results {
+		if len(r.Runs) != 5 {
+			t.Fatalf("expected 5 runs, got %d", len(r.Runs))
+		}
+		if r.Coverage.CILow > r.Coverage.Mean || r.Coverage.CIHigh < r.Coverage.Mean {
+			t.Fatalf("confidence interval %+v does not enclose the mean", r.Coverage)
+		}
+		if r.Protocol == "flooding" && r.Coverage.Mean != 1 {
+			t.Fatalf("expected flooding to reach every peer, got %+v", r.Coverage)
+		}
+	}
+
+	// protocols are compared over the same topologies and publishers
+	for i, run := range results[0].Runs {
+		if run.Seed != 40+i || run.Publisher != results[2].Runs[i].Publisher {
+			t.Fatalf("expected common seeds and publishers, got %+v and %+v", run, results[2].Runs[i])
+		}
+	}
+
+	gossip := results[2]
+	if gossip.Params["gossip_factor"] != 0.5 || gossip.Sent.Mean >= results[0].Sent.Mean {
+		t.Fatalf("expected gossip to send fewer messages than flooding, got %+v and %+v", gossip.Sent, results[0].Sent)
+	}
+
+	fmt.Println("- Test reproducibility")
+
+	sequential, err := experiment.Execute(newConfig(1))
+	if err != nil {
+		t.Fatalf("failed to run experiment: %v", err)
+	}
+
+	for i := range results {
+		if !reflect.DeepEqual(results[i].Coverage, sequential[i].Coverage) || !reflect.DeepEqual(results[i].LatencyMax, sequential[i].LatencyMax) {
+			t.Fatalf("expected parallel and sequential runs to match, got %+v and %+v", results[i], sequential[i])
+		}
+	}
+
+	fmt.Println("- Test estimates")
+
+	cfg := newConfig(0)
+	cfg.Graphs = cfg.Graphs[:1]
+	cfg.Protocols = cfg.Protocols[:1]
+	cfg.Params = nil
+	cfg.Repetitions = 1
+
+	single, err := experiment.Execute(cfg)
+	if err != nil {
+		t.Fatalf("failed to run experiment: %v", err)
+	}
+	if e := single[0].LatencyMax; e.StdDev != 0 || e.CILow != e.Mean || e.CIHigh != e.Mean {
+		t.Fatalf("expected a collapsed interval for a single run, got %+v", e)
+	}
+
+	cfg.Repetitions = 0
+	if _, err := experiment.Execute(cfg); err == nil {
+		t.Fatalf("expected an error without repetitions")
+	}
+
+	cfg.Repetitions = 1
+	cfg.Network.GossipSub = &p2p.GossipSubConfig{}
+	if _, err := experiment.Execute(cfg); err == nil {
+		t.Fatalf("expected an error for unlimited gossipsub heartbeats")
+	}
+
+	fmt.Println("- Test CSV")
+
+	var buf bytes.Buffer
+	if err := experiment.WriteCSV(&buf, results); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV: %v", err)
+	}
+	if len(records) != 9 || len(records[0]) != 5+6*4 || records[0][5] != "coverage_mean" {
+		t.Fatalf("unexpected CSV layout: %v", records[0])
+	}
+	if records[1][1] != "m=2;n=50" || records[2][3] != "gossip_factor=1" {
+		t.Fatalf("unexpected CSV parameters: %v %v", records[1][:4], records[2][:4])
+	}
+}
@@ -0,0 +1,544 @@
+package p2p
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// GossipSubConfig enables a GossipSub-style topic mesh. Every peer keeps, for each topic it subscribes to,
+// a mesh of subscribed neighbors whose size is kept between DLow and DHigh, and forwards the messages of the
+// topic to its mesh peers only. On every heartbeat, a peer repairs its meshes with GRAFT and PRUNE control
+// messages and sends IHAVE metadata about recently seen messages to DLazy other subscribed neighbors, which
+// can fetch the messages they are missing with IWANT. A peer that publishes to a topic it is not subscribed
+// to sends the message to D subscribed neighbors, its fanout for the topic.
+//
+// Only messages with a Topic are disseminated by the mesh; publish them with a nil protocol.
+// Subscriptions are made with Subscribe and exchanged between neighbors with control messages.
+type GossipSubConfig struct {
+	D                 int     // target mesh degree; zero means 6
+	DLow              int     // lower bound of the mesh degree; zero means 4
+	DHigh             int     // upper bound of the mesh degree; zero means 12
+	DLazy             int     // number of non-mesh peers receiving IHAVE metadata on each heartbeat; zero means 6
+	HeartbeatInterval float64 // time between two heartbeats of a peer, in milliseconds; zero means 1000
+	HistoryLength     int     // number of heartbeats a message is kept in the message cache; zero means 5
+	HistoryGossip     int     // number of heartbeats a message is advertised in IHAVE metadata; zero means 3
+	Heartbeats        int     // number of heartbeats of each peer; zero means unlimited, in which case the network never becomes quiescent and must be run with RunFor
+}
+
+// withDefaults returns a copy of the configuration with zero fields set to their default values.
+func (c GossipSubConfig) withDefaults() GossipSubConfig {
+	defaults := GossipSubConfig{D: 6, DLow: 4, DHigh: 12, DLazy: 6, HeartbeatInterval: 1000, HistoryLength: 5, HistoryGossip: 3}
+
+	if c.D == 0 {
+		c.D = defaults.D
+	}
+	if c.DLow == 0 {
+		c.DLow = defaults.DLow
+	}
+	if c.DHigh == 0 {
+		c.DHigh = defaults.DHigh
+	}
+	if c.DLazy == 0 {
+		c.DLazy = defaults.DLazy
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if c.HistoryLength == 0 {
+		c.HistoryLength = defaults.HistoryLength
+	}
+	if c.HistoryGossip == 0 {
+		c.HistoryGossip = defaults.HistoryGossip
+	}
+
+	return c
+}
+
+// validate returns an error if the mesh degrees or the history windows are inconsistent.
+func (c GossipSubConfig) validate() error {
+	if c.DLow < 0 || c.DLow > c.D || c.D > c.DHigh {
+		return fmt.Errorf("gossipsub degrees must satisfy 0 <= DLow <= D <= DHigh")
+	}
+	if c.DLazy < 0 || c.HeartbeatInterval < 0 || c.Heartbeats < 0 {
+		return fmt.Errorf("gossipsub DLazy, heartbeat interval and heartbeats must be non-negative")
+	}
+	if c.HistoryGossip < 0 || c.HistoryGossip > c.HistoryLength {
+		return fmt.Errorf("gossipsub history gossip must be between 0 and the history length")
+	}
+
+	return nil
+}
+
+// cacheEntry is a message ID kept in the message cache of a GossipSub peer.
+type cacheEntry struct {
+	topic string
+	msgID string
+}
+
+// gossipSub is the per-peer handler maintaining the topic meshes of GossipSub.
+type gossipSub struct {
+	cfg GossipSubConfig
+
+	peerTopics map[PeerID]map[string]struct{} // neighbor -> topics the neighbor subscribes to
+	mesh       map[string]map[PeerID]struct{} // topic -> mesh peers of a subscribed topic
+	fanout     map[string]map[PeerID]struct{} // topic -> peers receiving messages published to an unsubscribed topic
+	history    [][]cacheEntry                 // message cache, one window per heartbeat, most recent first
+	heartbeats int                            // number of heartbeats run by the peer
+	wanted     map[string]struct{}            // message IDs requested with IWANT since the last heartbeat
+	mu         sync.Mutex                     // mutex to protect access to the handler's state
+}
+
+// newGossipSub creates a GossipSub handler with empty meshes.
+func newGossipSub(cfg GossipSubConfig) *gossipSub {
+	return &gossipSub{
+		cfg:        cfg,
+		peerTopics: make(map[PeerID]map[string]struct{}),
+		mesh:       make(map[string]map[PeerID]struct{}),
+		fanout:     make(map[string]map[PeerID]struct{}),
+		history:    make([][]cacheEntry, 1),
+		wanted:     make(map[string]struct{}),
+	}
+}
+
+// start announces the peer's subscriptions to its neighbors and schedules its heartbeats,
+// the first of which builds the meshes of the subscribed topics.
+func (g *gossipSub) start(network *P2P, p *peer) {
+	for _, topic := range p.subscriptions() {
+		g.mu.Lock()
+		g.mesh[topic] = make(map[PeerID]struct{})
+		g.mu.Unlock()
+
+		for _, neighbor := range p.neighborIDs() {
+			p.send(network, neighbor, Message{Kind: KindSubscribe, Topic: topic})
+		}
+	}
+
+	offset := network.rng.Float64() * g.cfg.HeartbeatInterval
+
+	network.every(p, offset, g.cfg.HeartbeatInterval, func() bool {
+		g.heartbeats++
+		g.heartbeat(network, p)
+
+		return g.cfg.Heartbeats == 0 || g.heartbeats < g.cfg.Heartbeats
+	})
+}
+
+// subscribe joins or leaves a topic while the network is running: the change is announced to every neighbor,
+// and the mesh of the topic is built with GRAFT or torn down with PRUNE.
+func (g *gossipSub) subscribe(network *P2P, p *peer, topic string, subscribed bool) {
+	kind := KindUnsubscribe
+	if subscribed {
+		kind = KindSubscribe
+	}
+
+	for _, neighbor := range p.neighborIDs() {
+		p.send(network, neighbor, Message{Kind: kind, Topic: topic})
+	}
+
+	g.mu.Lock()
+
+	var targets []PeerID
+	control := KindPrune
+
+	if subscribed {
+		control = KindGraft
+		targets = sortedIDs(g.fanout[topic])
+		targets = targets[:min(g.cfg.D, len(targets))]
+		targets = append(targets, g.selectPeers(network, p, topic, g.cfg.D-len(targets), g.fanout[topic])...)
+
+		g.mesh[topic] = toSet(targets)
+		delete(g.fanout, topic)
+	} else {
+		targets = sortedIDs(g.mesh[topic])
+		delete(g.mesh, topic)
+	}
+
+	g.mu.Unlock()
+
+	for _, targetID := range targets {
+		p.send(network, targetID, Message{Kind: control, Topic: topic})
+	}
+}
+
+// receive forwards topic messages along the mesh and handles the control messages of GossipSub.
+func (g *gossipSub) receive(network *P2P, p *peer, msg Message, first bool) {
+	switch msg.Kind {
+	case KindPayload:
+		if first && msg.Topic != "" {
+			g.forward(network, p, msg)
+		}
+
+	case KindSubscribe, KindUnsubscribe:
+		g.mu.Lock()
+
+		if _, ok := g.peerTopics[msg.From]; !ok {
+			g.peerTopics[msg.From] = make(map[string]struct{})
+		}
+
+		if msg.Kind == KindSubscribe {
+			g.peerTopics[msg.From][msg.Topic] = struct{}{}
+		} else {
+			delete(g.peerTopics[msg.From], msg.Topic)
+			delete(g.mesh[msg.Topic], msg.From)
+			delete(g.fanout[msg.Topic], msg.From)
+		}
+
+		g.mu.Unlock()
+
+	case KindGraft:
+		g.mu.Lock()
+		mesh, subscribed := g.mesh[msg.Topic]
+		if subscribed {
+			mesh[msg.From] = struct{}{}
+		}
+		g.mu.Unlock()
+
+		if !subscribed {
+			p.send(network, msg.From, Message{Kind: KindPrune, Topic: msg.Topic})
+		}
+
+	case KindPrune:
+		g.mu.Lock()
+		delete(g.mesh[msg.Topic], msg.From)
+		g.mu.Unlock()
+
+	case KindIHave:
+		wants := make([]string, 0)
+
+		g.mu.Lock()
+		for _, msgID := range msg.Digest {
+			if _, ok := g.wanted[msgID]; ok || p.hasSeen(msgID) {
+				continue
+			}
+
+			g.wanted[msgID] = struct{}{}
+			wants = append(wants, msgID)
+		}
+		g.mu.Unlock()
+
+		if len(wants) > 0 {
+			p.send(network, msg.From, Message{Kind: KindIWant, Topic: msg.Topic, Digest: wants})
+		}
+
+	case KindIWant:
+		for _, msgID := range msg.Digest {
+			p.mu.Lock()
+			out, ok := p.messages[msgID]
+			p.mu.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			out.HopCount++
+			out.DynamicParams = nil
+
+			p.send(network, msg.From, out)
+		}
+	}
+}
+
+// forward caches a message seen for the first time and, after the peer's processing latency, sends it to the
+// mesh peers of its topic, or to the fanout peers if the peer publishes to a topic it is not subscribed to.
+func (g *gossipSub) forward(network *P2P, p *peer, msg Message) {
+	subscribed := p.isSubscribed(msg.Topic)
+
+	g.mu.Lock()
+
+	g.history[0] = append(g.history[0], cacheEntry{topic: msg.Topic, msgID: msg.ID})
+
+	var targets map[PeerID]struct{}
+
+	switch {
+	case subscribed:
+		targets = g.mesh[msg.Topic]
+	case msg.From == p.id:
+		if len(g.fanout[msg.Topic]) == 0 {
+			g.fanout[msg.Topic] = toSet(g.selectPeers(network, p, msg.Topic, g.cfg.D, nil))
+		}
+
+		targets = g.fanout[msg.Topic]
+	}
+
+	ids := sortedIDs(targets)
+
+	g.mu.Unlock()
+
+	network.after(p.processingLatency, func() {
+		for _, targetID := range ids {
+			if targetID == msg.From || targetID == msg.Publisher {
+				continue
+			}
+
+			out := msg
+			out.HopCount++
+			out.DynamicParams = nil
+
+			p.send(network, targetID, out)
+		}
+	})
+}
+
+// heartbeat repairs the meshes of the peer, emits IHAVE metadata and shifts the message cache.
+func (g *gossipSub) heartbeat(network *P2P, p *peer) {
+	neighbors := toSet(p.neighborIDs())
+
+	type control struct {
+		target PeerID
+		msg    Message
+	}
+
+	controls := make([]control, 0)
+
+	g.mu.Lock()
+
+	for _, topic := range sortedKeys(g.mesh) {
+		mesh := g.mesh[topic]
+
+		for id := range mesh {
+			if _, ok := neighbors[id]; !ok || !g.subscribes(id, topic) {
+				delete(mesh, id)
+			}
+		}
+
+		if len(mesh) < g.cfg.DLow {
+			for _, id := range g.selectPeers(network, p, topic, g.cfg.D-len(mesh), mesh) {
+				mesh[id] = struct{}{}
+				controls = append(controls, control{id, Message{Kind: KindGraft, Topic: topic}})
+			}
+		}
+
+		if len(mesh) > g.cfg.DHigh {
+			members := sortedIDs(mesh)
+			network.rng.Shuffle(len(members), func(i, j int) {
+				members[i], members[j] = members[j], members[i]
+			})
+
+			for _, id := range members[g.cfg.D:] {
+				delete(mesh, id)
+				controls = append(controls, control{id, Message{Kind: KindPrune, Topic: topic}})
+			}
+		}
+	}
+
+	for _, topic := range sortedKeys(g.fanout) {
+		for id := range g.fanout[topic] {
+			if _, ok := neighbors[id]; !ok || !g.subscribes(id, topic) {
+				delete(g.fanout[topic], id)
+			}
+		}
+	}
+
+	gossip := make(map[string][]string)
+	for _, window := range g.history[:min(g.cfg.HistoryGossip, len(g.history))] {
+		for _, entry := range window {
+			gossip[entry.topic] = append(gossip[entry.topic], entry.msgID)
+		}
+	}
+
+	for _, topic := range sortedKeys(gossip) {
+		exclude := g.mesh[topic]
+		if exclude == nil {
+			exclude = g.fanout[topic]
+		}
+
+		for _, id := range g.selectPeers(network, p, topic, g.cfg.DLazy, exclude) {
+			controls = append(controls, control{id, Message{Kind: KindIHave, Topic: topic, Digest: slices.Clone(gossip[topic])}})
+		}
+	}
+
+	g.history = append([][]cacheEntry{{}}, g.history...)
+	if len(g.history) > g.cfg.HistoryLength {
+		g.history = g.history[:g.cfg.HistoryLength]
+	}
+
+	g.wanted = make(map[string]struct{})
+
+	g.mu.Unlock()
+
+	for _, c := range controls {
+		p.send(network, c.target, c.msg)
+	}
+}
+
+// selectPeers returns up to n random neighbors subscribed to the topic that are not in exclude.
+// The caller must hold the handler's mutex.
+func (g *gossipSub) selectPeers(network *P2P, p *peer, topic string, n int, exclude map[PeerID]struct{}) []PeerID {
+	candidates := make([]PeerID, 0)
+
+	for _, id := range p.neighborIDs() {
+		if _, ok := exclude[id]; ok {
+			continue
+		}
+		if g.subscribes(id, topic) {
+			candidates = append(candidates, id)
+		}
+	}
+
+	network.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	if n < 0 {
+		n = 0
+	}
+
+	return candidates[:min(n, len(candidates))]
+}
+
+// subscribes reports whether the neighbor announced a subscription to the topic.
+// The caller must hold the handler's mutex.
+func (g *gossipSub) subscribes(id PeerID, topic string) bool {
+	_, ok := g.peerTopics[id][topic]
+
+	return ok
+}
+
+/* Subscriptions */
+
+// Subscribe subscribes the peer to a topic. With Config.GossipSub, the subscription is announced to the peer's
+// neighbors, and the peer joins the mesh of the topic if the network is already running.
+func (p *P2P) Subscribe(id PeerID, topic string) error {
+	return p.setSubscription(id, topic, true)
+}
+
+// Unsubscribe unsubscribes the peer from a topic and, with Config.GossipSub, leaves the mesh of the topic.
+func (p *P2P) Unsubscribe(id PeerID, topic string) error {
+	return p.setSubscription(id, topic, false)
+}
+
+// Subscribers returns the sorted IDs of the peers subscribed to the topic.
+func (p *P2P) Subscribers(topic string) []PeerID {
+	ids := make([]PeerID, 0)
+
+	for _, n := range p.peerList() {
+		if n.isSubscribed(topic) {
+			ids = append(ids, n.id)
+		}
+	}
+
+	return ids
+}
+
+// Mesh returns the sorted IDs of the peers in the GossipSub mesh of the peer for the topic.
+// It returns an error if the peer does not exist or GossipSub is not enabled.
+func (p *P2P) Mesh(id PeerID, topic string) ([]PeerID, error) {
+	n, ok := p.peer(id)
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", id)
+	}
+
+	for _, h := range n.handlers {
+		if g, ok := h.(*gossipSub); ok {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+
+			return sortedIDs(g.mesh[topic]), nil
+		}
+	}
+
+	return nil, fmt.Errorf("gossipsub is not enabled")
+}
+
+// setSubscription updates the subscription of a peer and notifies its GossipSub handler if the network is running.
+func (p *P2P) setSubscription(id PeerID, topic string, subscribed bool) error {
+	if topic == "" {
+		return fmt.Errorf("topic must not be empty")
+	}
+
+	n, ok := p.peer(id)
+	if !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	n.mu.Lock()
+	_, was := n.topics[topic]
+	if subscribed {
+		n.topics[topic] = struct{}{}
+	} else {
+		delete(n.topics, topic)
+	}
+	n.mu.Unlock()
+
+	if was == subscribed {
+		return nil
+	}
+
+	p.mu.RLock()
+	running := p.running
+	p.mu.RUnlock()
+
+	if !running {
+		return nil
+	}
+
+	for _, h := range n.handlers {
+		if g, ok := h.(*gossipSub); ok {
+			g.subscribe(p, n, topic, subscribed)
+		}
+	}
+
+	return nil
+}
+
+// subscriptions returns the sorted topics the peer subscribes to.
+func (p *peer) subscriptions() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	topics := make([]string, 0, len(p.topics))
+	for topic := range p.topics {
+		topics = append(topics, topic)
+	}
+
+	slices.Sort(topics)
+
+	return topics
+}
+
+// isSubscribed reports whether the peer subscribes to the topic.
+func (p *peer) isSubscribed(topic string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.topics[topic]
+
+	return ok
+}
+
+/* Utility Functions */
+
+// toSet returns the set of the given peer IDs.
+func toSet(ids []PeerID) map[PeerID]struct{} {
+	set := make(map[PeerID]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	return set
+}
+
+// sortedIDs returns the sorted peer IDs of a set.
+func sortedIDs(set map[PeerID]struct{}) []PeerID {
+	ids := make([]PeerID, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// sortedKeys returns the sorted keys of a map keyed by topic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
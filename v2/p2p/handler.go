@@ -0,0 +1,187 @@
+package p2p
+
+import "slices"
+
+// handler is a built-in protocol attached to every peer, for protocols that keep per-peer state or act on
+// timers and control messages rather than only on the first reception of a message.
+// Each peer has its own handler instances, created from the network configuration by newHandlers.
+type handler interface {
+	// start is called once when the peer starts running.
+	start(network *P2P, p *peer)
+	// receive is called for every message handled by a live peer. first reports whether the message is the
+	// first reception of its message ID, and is always false for control messages.
+	receive(network *P2P, p *peer, msg Message, first bool)
+}
+
+// forwarder is implemented by handlers that take over the transmission of the messages a ProtocolFunc forwards,
+// for instance to announce them instead of sending them.
+type forwarder interface {
+	// forward is called for every target chosen by the ProtocolFunc, with the message that would be sent to it.
+	forward(network *P2P, p *peer, targetID PeerID, msg Message)
+}
+
+// newHandlers creates the handlers of a peer for the built-in protocols enabled in the configuration,
+// followed by the peer's stateful protocol, if any.
+func (p *P2P) newHandlers(id PeerID) []handler {
+	handlers := make([]handler, 0)
+
+	if p.cfg.AntiEntropy != nil {
+		handlers = append(handlers, &antiEntropy{cfg: p.cfg.AntiEntropy})
+	}
+	if p.cfg.Announce != nil {
+		handlers = append(handlers, newAnnouncer(p.cfg.Announce))
+	}
+	if p.cfg.GossipSub != nil {
+		handlers = append(handlers, newGossipSub(p.cfg.GossipSub.withDefaults()))
+	}
+	if p.cfg.Plumtree != nil {
+		handlers = append(handlers, newPlumtree(p.cfg.Plumtree))
+	}
+	if p.cfg.Dandelion != nil {
+		handlers = append(handlers, newDandelion(p.cfg.Dandelion))
+	}
+	if p.cfg.Protocol != nil {
+		handlers = append(handlers, &protocolHandler{protocol: p.cfg.Protocol(id)})
+	}
+
+	return handlers
+}
+
+// startHandlers starts every handler of the peer.
+func (p *peer) startHandlers(network *P2P) {
+	for _, h := range p.handlers {
+		h.start(network, p)
+	}
+}
+
+// forwarder returns the first handler of the peer that takes over the transmission of forwarded messages, or nil.
+func (p *peer) forwarder() forwarder {
+	for _, h := range p.handlers {
+		if f, ok := h.(forwarder); ok {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// send transmits a message to a neighbor, recording it like the messages forwarded by protocols, after the peer's
+// behavior, if any, has had its say. Payload messages are also recorded as sent to the target.
+// It returns false if the message was dropped, the peer is not alive or it has no link to the target.
+func (p *peer) send(network *P2P, targetID PeerID, msg Message) bool {
+	msg.From = p.id
+	msg.Rand = network.rng
+
+	if p.behavior != nil {
+		var delay float64
+		var ok bool
+
+		if msg, delay, ok = p.behavior(p.id, targetID, msg); !ok {
+			return false
+		}
+
+		if delay > 0 {
+			network.after(delay, func() {
+				p.sendNow(network, targetID, msg)
+			})
+
+			return true
+		}
+	}
+
+	return p.sendNow(network, targetID, msg)
+}
+
+// sendNow records and transmits a message to a neighbor.
+func (p *peer) sendNow(network *P2P, targetID PeerID, msg Message) bool {
+	defer network.writeTrace(p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.alive {
+		return false
+	}
+
+	e, ok := p.edges[targetID]
+	if !ok {
+		return false
+	}
+
+	// built-in protocols resend messages one hop further, which must not take them beyond their hop limit
+	if msg.Kind == KindPayload && msg.MaxHops > 0 && msg.HopCount > msg.MaxHops {
+		return false
+	}
+
+	if msg.Kind == KindPayload {
+		if _, ok := p.sentTo[msg.ID]; !ok {
+			p.sentTo[msg.ID] = make(map[PeerID]struct{})
+		}
+		p.sentTo[msg.ID][targetID] = struct{}{}
+	}
+
+	network.record(p, TraceEvent{
+		Type:    EventSend,
+		Kind:    msg.Kind,
+		From:    p.id,
+		To:      targetID,
+		ID:      msg.ID,
+		Content: msg.Content,
+		Hop:     msg.HopCount,
+		Time:    network.now(),
+	})
+
+	p.transmit(network, e, msg)
+
+	return true
+}
+
+// receiveControl records the reception of a control message.
+func (p *peer) receiveControl(network *P2P, msg Message) {
+	defer network.writeTrace(p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	network.record(p, TraceEvent{
+		Type:    EventRecv,
+		Kind:    msg.Kind,
+		From:    msg.From,
+		To:      p.id,
+		ID:      msg.ID,
+		Content: msg.Content,
+		Hop:     msg.HopCount,
+		Time:    network.now(),
+	})
+}
+
+// neighborIDs returns the sorted IDs of the peer's neighbors.
+func (p *peer) neighborIDs() []PeerID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]PeerID, 0, len(p.edges))
+	for targetID := range p.edges {
+		ids = append(ids, targetID)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// every runs fn for the peer after the given offset and then at every interval, both in milliseconds,
+// until the peer is stopped or fn returns false. Ticks that occur while the peer is crashed are skipped.
+func (p *P2P) every(n *peer, offset, interval float64, fn func() bool) {
+	p.after(offset, func() {
+		if n.isStopped() {
+			return
+		}
+
+		if n.isAlive() && !fn() {
+			return
+		}
+
+		p.every(n, interval, interval, fn)
+	})
+}
@@ -0,0 +1,91 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"math/rand/v2"
+
+	"github.com/elecbug/netkit/v2/p2p"
+)
+
+// IDBits is the number of bits of an ID, and therefore the number of k-buckets of a routing table.
+const IDBits = 256
+
+// ID is a position in the XOR key space, shared by peers and stored values.
+type ID [IDBits / 8]byte
+
+// NewID returns the ID of a peer, the SHA-256 hash of its PeerID.
+func NewID(id p2p.PeerID) ID {
+	return sha256.Sum256([]byte(id))
+}
+
+// KeyID returns the ID of a stored value, the SHA-256 hash of its key.
+func KeyID(key string) ID {
+	return sha256.Sum256([]byte(key))
+}
+
+// Xor returns the XOR distance between two IDs.
+func (a ID) Xor(b ID) ID {
+	var d ID
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+
+	return d
+}
+
+// Compare compares two IDs, or two distances, as big-endian unsigned integers.
+func (a ID) Compare(b ID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// String returns the hexadecimal representation of the ID.
+func (a ID) String() string {
+	return hex.EncodeToString(a[:])
+}
+
+// CommonPrefixLen returns the number of leading bits shared by two IDs, which is the index of the k-bucket
+// one of them belongs to in the routing table of the other. It returns IDBits for equal IDs.
+func CommonPrefixLen(a, b ID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+
+	return IDBits
+}
+
+// randomIDInBucket returns a random ID whose common prefix with self is exactly the given length.
+func randomIDInBucket(self ID, prefix int, rng *rand.Rand) ID {
+	var id ID
+	for i := range id {
+		id[i] = byte(rng.UintN(256))
+	}
+
+	for i := 0; i < prefix; i++ {
+		setBit(&id, i, bit(self, i))
+	}
+
+	setBit(&id, prefix, 1-bit(self, prefix))
+
+	return id
+}
+
+// bit returns the i-th most significant bit of the ID.
+func bit(id ID, i int) byte {
+	return (id[i/8] >> (7 - i%8)) & 1
+}
+
+// setBit sets the i-th most significant bit of the ID.
+func setBit(id *ID, i int, v byte) {
+	mask := byte(1) << (7 - i%8)
+
+	if v == 0 {
+		id[i/8] &^= mask
+	} else {
+		id[i/8] |= mask
+	}
+}
@@ -0,0 +1,249 @@
+// Package kademlia simulates a Kademlia distributed hash table over the peers of a P2P network.
+// Peers are assigned IDs in a XOR key space and keep k-buckets of contacts; iterative FIND_NODE and
+// FIND_VALUE lookups are simulated in virtual time with the latency functions of the P2P network,
+// so that lookup hop counts, lookup latencies and the health of routing tables can be studied.
+//
+// Like the IP layer underneath a real DHT, any peer can send an RPC to any other peer it knows about;
+// the links of the P2P overlay are not used. A DHT is not safe for concurrent use.
+package kademlia
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/elecbug/netkit/v2/p2p"
+)
+
+// Config holds the parameters of the DHT.
+type Config struct {
+	K       int         // size of the k-buckets and of lookup results; zero means 20
+	Alpha   int         // number of RPCs a lookup keeps in flight; zero means 3
+	Timeout float64     // time after which an RPC to a failed peer is given up, in milliseconds; zero means 1000
+	Network *p2p.Config // configuration whose latency functions time the RPCs; NetworkLatencyFunc is required
+	Seed    uint64      // seed of the random choices made while joining, refreshing and generating lookups
+}
+
+// DHT is a simulated Kademlia distributed hash table.
+type DHT struct {
+	cfg   Config
+	nodes map[p2p.PeerID]*node
+	ids   []p2p.PeerID // sorted IDs of all peers
+	rng   *rand.Rand
+}
+
+// node is the state of a single peer of the DHT.
+type node struct {
+	peer    p2p.PeerID
+	id      ID
+	buckets [IDBits][]p2p.PeerID // contacts by common prefix length with id, least recently seen first
+	values  map[ID]struct{}      // keys of the values stored at the peer
+	failed  bool                 // indicates whether the peer has failed and no longer answers RPCs
+}
+
+// New creates a DHT with the given peers, which join one after the other in a random order: each joining peer
+// learns a random peer that joined before it and looks up its own ID. Call Refresh afterwards to fill the
+// buckets of the routing tables as a long-running DHT would.
+func New(peers []p2p.PeerID, cfg *Config) (*DHT, error) {
+	if cfg.Network == nil || cfg.Network.NetworkLatencyFunc == nil {
+		return nil, fmt.Errorf("network latency function is required")
+	}
+	if cfg.K < 0 || cfg.Alpha < 0 || cfg.Timeout < 0 {
+		return nil, fmt.Errorf("k, alpha and timeout must be non-negative")
+	}
+
+	d := &DHT{
+		cfg:   *cfg,
+		nodes: make(map[p2p.PeerID]*node),
+		ids:   slices.Clone(peers),
+		rng:   p2p.NewRand(cfg.Seed),
+	}
+
+	if d.cfg.K == 0 {
+		d.cfg.K = 20
+	}
+	if d.cfg.Alpha == 0 {
+		d.cfg.Alpha = 3
+	}
+	if d.cfg.Timeout == 0 {
+		d.cfg.Timeout = 1000
+	}
+
+	slices.Sort(d.ids)
+
+	for _, id := range d.ids {
+		if _, ok := d.nodes[id]; ok {
+			return nil, fmt.Errorf("duplicate peer %s", id)
+		}
+
+		d.nodes[id] = &node{peer: id, id: NewID(id), values: make(map[ID]struct{})}
+	}
+
+	order := slices.Clone(d.ids)
+	d.rng.Shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+
+	for i, id := range order {
+		if i == 0 {
+			continue
+		}
+
+		n := d.nodes[id]
+		d.insert(n, order[d.rng.IntN(i)])
+		d.lookup(n, n.id, false)
+	}
+
+	return d, nil
+}
+
+// Refresh makes every live peer look up a random ID in each bucket range up to its deepest non-empty bucket,
+// which fills its routing table with the contacts learned along the way.
+func (d *DHT) Refresh() {
+	for _, id := range d.ids {
+		n := d.nodes[id]
+		if n.failed {
+			continue
+		}
+
+		deepest := -1
+		for i := range n.buckets {
+			if len(n.buckets[i]) > 0 {
+				deepest = i
+			}
+		}
+
+		for i := 0; i <= deepest && i < IDBits; i++ {
+			d.lookup(n, randomIDInBucket(n.id, i, d.rng), false)
+		}
+	}
+}
+
+// Fail marks the peer as failed. A failed peer does not answer RPCs, so lookups that contact it wait for Config.Timeout.
+func (d *DHT) Fail(id p2p.PeerID) error {
+	n, ok := d.nodes[id]
+	if !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	n.failed = true
+
+	return nil
+}
+
+// Recover brings a failed peer back with the routing table and values it had before failing.
+func (d *DHT) Recover(id p2p.PeerID) error {
+	n, ok := d.nodes[id]
+	if !ok {
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	n.failed = false
+
+	return nil
+}
+
+// ID returns the ID of the peer in the key space.
+func (d *DHT) ID(id p2p.PeerID) (ID, error) {
+	n, ok := d.nodes[id]
+	if !ok {
+		return ID{}, fmt.Errorf("peer %s not found", id)
+	}
+
+	return n.id, nil
+}
+
+// Buckets returns a copy of the k-buckets of the peer, indexed by common prefix length, each ordered from the
+// least to the most recently seen contact. Empty buckets beyond the deepest non-empty one are omitted.
+func (d *DHT) Buckets(id p2p.PeerID) ([][]p2p.PeerID, error) {
+	n, ok := d.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", id)
+	}
+
+	buckets := make([][]p2p.PeerID, 0)
+	for i := range n.buckets {
+		if len(n.buckets[i]) > 0 {
+			for len(buckets) < i {
+				buckets = append(buckets, []p2p.PeerID{})
+			}
+
+			buckets = append(buckets, slices.Clone(n.buckets[i]))
+		}
+	}
+
+	return buckets, nil
+}
+
+/* Routing Tables */
+
+// insert records a contact seen by the peer. A known contact moves to the tail of its bucket; a new contact is
+// appended if the bucket is not full, replaces the least recently seen contact if that contact has failed, and
+// is dropped otherwise, since Kademlia prefers long-lived contacts.
+func (d *DHT) insert(n *node, contact p2p.PeerID) {
+	if contact == n.peer {
+		return
+	}
+
+	c, ok := d.nodes[contact]
+	if !ok {
+		return
+	}
+
+	i := CommonPrefixLen(n.id, c.id)
+	if i >= IDBits {
+		return
+	}
+
+	bucket := n.buckets[i]
+
+	if j := slices.Index(bucket, contact); j >= 0 {
+		n.buckets[i] = append(slices.Delete(bucket, j, j+1), contact)
+		return
+	}
+
+	if len(bucket) < d.cfg.K {
+		n.buckets[i] = append(bucket, contact)
+		return
+	}
+
+	if d.nodes[bucket[0]].failed {
+		n.buckets[i] = append(bucket[1:], contact)
+	}
+}
+
+// remove deletes a contact from the peer's routing table.
+func (d *DHT) remove(n *node, contact p2p.PeerID) {
+	c, ok := d.nodes[contact]
+	if !ok {
+		return
+	}
+
+	i := CommonPrefixLen(n.id, c.id)
+	if i >= IDBits {
+		return
+	}
+
+	if j := slices.Index(n.buckets[i], contact); j >= 0 {
+		n.buckets[i] = slices.Delete(n.buckets[i], j, j+1)
+	}
+}
+
+// closest returns up to count contacts of the peer closest to the target, nearest first.
+func (d *DHT) closest(n *node, target ID, count int) []p2p.PeerID {
+	contacts := make([]p2p.PeerID, 0)
+	for i := range n.buckets {
+		contacts = append(contacts, n.buckets[i]...)
+	}
+
+	d.sortByDistance(contacts, target)
+
+	return contacts[:min(count, len(contacts))]
+}
+
+// sortByDistance sorts peers by their XOR distance to the target, nearest first.
+func (d *DHT) sortByDistance(peers []p2p.PeerID, target ID) {
+	slices.SortFunc(peers, func(a, b p2p.PeerID) int {
+		return d.nodes[a].id.Xor(target).Compare(d.nodes[b].id.Xor(target))
+	})
+}
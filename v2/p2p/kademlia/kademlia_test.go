@@ -0,0 +1,158 @@
+package kademlia_test
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/elecbug/netkit/v2/p2p"
+	"github.com/elecbug/netkit/v2/p2p/kademlia"
+)
+
+// TestKademlia verifies routing table construction, iterative lookups and their statistics,
+// with and without failed peers.
+func TestKademlia(t *testing.T) {
+	fmt.Println("Test Kademlia")
+
+	peers := make([]p2p.PeerID, 200)
+	for i := range peers {
+		peers[i] = p2p.PeerID(fmt.Sprintf("%d", i))
+	}
+
+	newDHT := func() *kademlia.DHT {
+		dht, err := kademlia.New(peers, &kademlia.Config{
+			K:     8,
+			Alpha: 3,
+			Network: &p2p.Config{
+				ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+				NetworkLatencyFunc: func(src, dst p2p.PeerID) float64 {
+					var a, b int
+					fmt.Sscanf(string(src), "%d", &a)
+					fmt.Sscanf(string(dst), "%d", &b)
+
+					return float64(10 + (a+b)%30)
+				},
+			},
+			Seed: 5,
+		})
+		if err != nil {
+			t.Fatalf("failed to create DHT: %v", err)
+		}
+
+		dht.Refresh()
+
+		return dht
+	}
+
+	dht := newDHT()
+
+	fmt.Println("- Test routing tables")
+
+	health := dht.Health()
+	if health.Completeness < 0.9 || health.Stale != 0 {
+		t.Fatalf("expected healthy routing tables, got %+v", health)
+	}
+
+	buckets, err := dht.Buckets("0")
+	if err != nil {
+		t.Fatalf("failed to get buckets: %v", err)
+	}
+	self, _ := dht.ID("0")
+	for i, bucket := range buckets {
+		if len(bucket) > 8 {
+			t.Fatalf("expected buckets of at most 8 contacts, got %d", len(bucket))
+		}
+		for _, contact := range bucket {
+			if id, _ := dht.ID(contact); kademlia.CommonPrefixLen(self, id) != i {
+				t.Fatalf("expected contact %s in bucket %d", contact, i)
+			}
+		}
+	}
+
+	fmt.Println("- Test FIND_NODE")
+
+	target := kademlia.KeyID("target")
+	res, err := dht.FindNode("0", target)
+	if err != nil {
+		t.Fatalf("failed to look up node: %v", err)
+	}
+
+	expected := slices.Clone(peers)
+	slices.SortFunc(expected, func(a, b p2p.PeerID) int {
+		ia, _ := dht.ID(a)
+		ib, _ := dht.ID(b)
+
+		return ia.Xor(target).Compare(ib.Xor(target))
+	})
+	expected = slices.DeleteFunc(expected, func(id p2p.PeerID) bool { return id == "0" })[:8]
+
+	if !slices.Equal(res.Closest, expected) {
+		t.Fatalf("expected closest peers %v, got %v", expected, res.Closest)
+	}
+	if res.Hops < 1 || res.Latency <= 0 || res.Messages < len(res.Closest) {
+		t.Fatalf("unexpected lookup result: %+v", res)
+	}
+
+	fmt.Println("- Test FIND_VALUE")
+
+	if _, err := dht.Store("1", "value"); err != nil {
+		t.Fatalf("failed to store value: %v", err)
+	}
+
+	found, err := dht.FindValue("150", "value")
+	if err != nil {
+		t.Fatalf("failed to look up value: %v", err)
+	}
+	if !found.Found || found.Latency <= 0 {
+		t.Fatalf("expected value to be found, got %+v", found)
+	}
+
+	fmt.Println("- Test lookup statistics")
+
+	summary := kademlia.Summarize(dht.RandomLookups(100))
+
+	total := 0
+	for _, count := range summary.Hops {
+		total += count
+	}
+
+	if summary.Lookups != 100 || total != 100 || summary.MeanHops > 8 {
+		t.Fatalf("unexpected lookup summary: %+v", summary)
+	}
+	if summary.LatencyP50 > summary.LatencyP90 || summary.LatencyP90 > summary.LatencyMax {
+		t.Fatalf("expected ordered latency percentiles, got %+v", summary)
+	}
+
+	again := newDHT()
+	again.Store("1", "value")
+	again.FindValue("150", "value")
+	if !reflect.DeepEqual(kademlia.Summarize(again.RandomLookups(100)), summary) {
+		t.Fatalf("expected identical lookups with the same seed")
+	}
+
+	fmt.Println("- Test failures")
+
+	for i := 0; i < len(peers); i += 5 {
+		if err := dht.Fail(peers[i+1]); err != nil {
+			t.Fatalf("failed to fail peer: %v", err)
+		}
+	}
+
+	if health := dht.Health(); health.Stale == 0 {
+		t.Fatalf("expected stale contacts after failures")
+	}
+
+	failed := kademlia.Summarize(dht.RandomLookups(100))
+	if failed.Timeouts == 0 || failed.LatencyMax <= summary.LatencyMax {
+		t.Fatalf("expected timeouts to slow lookups down, got %+v", failed)
+	}
+
+	found, err = dht.FindValue("150", "value")
+	if err != nil {
+		t.Fatalf("failed to look up value: %v", err)
+	}
+	if !found.Found {
+		t.Fatalf("expected value to be found on a replica")
+	}
+}
@@ -0,0 +1,281 @@
+package kademlia
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/elecbug/netkit/v2/p2p"
+)
+
+// LookupResult describes a simulated iterative lookup.
+type LookupResult struct {
+	Origin   p2p.PeerID   `json:"origin"`   // peer that performed the lookup
+	Target   ID           `json:"target"`   // ID that was looked up
+	Closest  []p2p.PeerID `json:"closest"`  // up to K responding peers closest to the target, nearest first
+	Found    bool         `json:"found"`    // indicates whether a FIND_VALUE lookup found the value
+	Holder   p2p.PeerID   `json:"holder"`   // peer that returned the value of a successful FIND_VALUE lookup
+	Hops     int          `json:"hops"`     // number of sequential RPCs that led to the holder of the value, or to the closest peer
+	Latency  float64      `json:"latency"`  // time from the start of the lookup to its end, in milliseconds
+	Messages int          `json:"messages"` // number of RPCs sent
+	Timeouts int          `json:"timeouts"` // number of RPCs sent to failed peers
+}
+
+// candidate is a peer considered by a lookup.
+type candidate struct {
+	peer     p2p.PeerID
+	distance ID
+	depth    int // number of sequential RPCs needed to learn about the peer, plus one
+	state    candidateState
+}
+
+// candidateState is the progress of the RPC to a lookup candidate.
+type candidateState int
+
+const (
+	candidateFresh candidateState = iota
+	candidateQueried
+	candidateResponded
+	candidateFailed
+)
+
+// reply is the response or the timeout of an RPC, at the time it reaches the origin of the lookup.
+type reply struct {
+	at   float64
+	seq  int
+	cand *candidate
+}
+
+// FindNode performs an iterative FIND_NODE lookup of the target from the given peer.
+func (d *DHT) FindNode(origin p2p.PeerID, target ID) (*LookupResult, error) {
+	n, err := d.live(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.lookup(n, target, false), nil
+}
+
+// FindValue performs an iterative FIND_VALUE lookup of the key from the given peer. The lookup ends as soon as
+// a contacted peer holds the value.
+func (d *DHT) FindValue(origin p2p.PeerID, key string) (*LookupResult, error) {
+	n, err := d.live(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.lookup(n, KeyID(key), true), nil
+}
+
+// Store looks up the K peers closest to the key from the given peer and stores the value at each of them.
+// The returned result counts the STORE RPCs among its messages.
+func (d *DHT) Store(origin p2p.PeerID, key string) (*LookupResult, error) {
+	n, err := d.live(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	target := KeyID(key)
+	res := d.lookup(n, target, false)
+
+	for _, id := range res.Closest {
+		d.nodes[id].values[target] = struct{}{}
+		res.Messages++
+	}
+
+	return res, nil
+}
+
+// RandomLookups performs count FIND_NODE lookups of random IDs from random live peers.
+func (d *DHT) RandomLookups(count int) []*LookupResult {
+	live := make([]*node, 0, len(d.ids))
+	for _, id := range d.ids {
+		if !d.nodes[id].failed {
+			live = append(live, d.nodes[id])
+		}
+	}
+
+	results := make([]*LookupResult, 0, count)
+	if len(live) == 0 {
+		return results
+	}
+
+	for range count {
+		var target ID
+		for i := range target {
+			target[i] = byte(d.rng.UintN(256))
+		}
+
+		results = append(results, d.lookup(live[d.rng.IntN(len(live))], target, false))
+	}
+
+	return results
+}
+
+// lookup simulates an iterative lookup in virtual time. The origin keeps up to Alpha RPCs in flight to the
+// closest candidates it has not queried yet, among the K closest candidates that have not failed, and ends
+// when all of those have responded. Every responding peer returns its K contacts closest to the target.
+// The origin and the contacted peers learn about each other as the RPCs are answered.
+func (d *DHT) lookup(origin *node, target ID, value bool) *LookupResult {
+	res := &LookupResult{Origin: origin.peer, Target: target, Closest: make([]p2p.PeerID, 0)}
+
+	if _, ok := origin.values[target]; value && ok {
+		res.Found = true
+		res.Holder = origin.peer
+
+		return res
+	}
+
+	candidates := make([]*candidate, 0)
+	known := make(map[p2p.PeerID]struct{})
+
+	add := func(id p2p.PeerID, depth int) {
+		if _, ok := known[id]; ok || id == origin.peer {
+			return
+		}
+
+		known[id] = struct{}{}
+		candidates = append(candidates, &candidate{peer: id, distance: d.nodes[id].id.Xor(target), depth: depth})
+	}
+
+	for _, id := range d.closest(origin, target, d.cfg.K) {
+		add(id, 1)
+	}
+
+	now := 0.0
+	seq := 0
+	inFlight := make([]reply, 0)
+
+	launch := func() {
+		slices.SortFunc(candidates, func(a, b *candidate) int {
+			return a.distance.Compare(b.distance)
+		})
+
+		considered := 0
+		for _, c := range candidates {
+			if len(inFlight) >= d.cfg.Alpha || considered >= d.cfg.K {
+				break
+			}
+			if c.state == candidateFailed {
+				continue
+			}
+
+			considered++
+
+			if c.state != candidateFresh {
+				continue
+			}
+
+			c.state = candidateQueried
+			res.Messages++
+
+			at := now + d.cfg.Timeout
+			if peer := d.nodes[c.peer]; !peer.failed {
+				at = now + d.rtt(origin.peer, c.peer)
+			}
+
+			inFlight = append(inFlight, reply{at: at, seq: seq, cand: c})
+			seq++
+		}
+	}
+
+	launch()
+
+	for len(inFlight) > 0 {
+		next := 0
+		for i, r := range inFlight {
+			if r.at < inFlight[next].at || (r.at == inFlight[next].at && r.seq < inFlight[next].seq) {
+				next = i
+			}
+		}
+
+		r := inFlight[next]
+		inFlight = slices.Delete(inFlight, next, next+1)
+		now = r.at
+
+		c := r.cand
+		peer := d.nodes[c.peer]
+
+		if peer.failed {
+			c.state = candidateFailed
+			res.Timeouts++
+			d.remove(origin, c.peer)
+		} else {
+			c.state = candidateResponded
+			d.insert(origin, c.peer)
+			d.insert(peer, origin.peer)
+
+			if _, ok := peer.values[target]; value && ok {
+				res.Found = true
+				res.Holder = c.peer
+				res.Hops = c.depth
+				res.Latency = now
+				res.Closest = respondedClosest(candidates, d.cfg.K)
+
+				return res
+			}
+
+			for _, id := range d.closest(peer, target, d.cfg.K) {
+				add(id, c.depth+1)
+			}
+		}
+
+		launch()
+	}
+
+	res.Latency = now
+	res.Closest = respondedClosest(candidates, d.cfg.K)
+
+	if len(res.Closest) > 0 {
+		for _, c := range candidates {
+			if c.peer == res.Closest[0] {
+				res.Hops = c.depth
+			}
+		}
+	}
+
+	return res
+}
+
+// rtt returns the round-trip time of an RPC from src to dst, including the processing latency of dst, in milliseconds.
+func (d *DHT) rtt(src, dst p2p.PeerID) float64 {
+	rtt := d.cfg.Network.NetworkLatencyFunc(src, dst) + d.cfg.Network.NetworkLatencyFunc(dst, src)
+
+	if d.cfg.Network.ProcessingLatencyFunc != nil {
+		rtt += d.cfg.Network.ProcessingLatencyFunc(dst)
+	}
+
+	return rtt
+}
+
+// live returns the node of a peer that has not failed.
+func (d *DHT) live(id p2p.PeerID) (*node, error) {
+	n, ok := d.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", id)
+	}
+	if n.failed {
+		return nil, fmt.Errorf("peer %s has failed", id)
+	}
+
+	return n, nil
+}
+
+// respondedClosest sorts the candidates by distance and returns up to k of those that responded.
+func respondedClosest(candidates []*candidate, k int) []p2p.PeerID {
+	slices.SortFunc(candidates, func(a, b *candidate) int {
+		return a.distance.Compare(b.distance)
+	})
+
+	closest := make([]p2p.PeerID, 0, k)
+
+	for _, c := range candidates {
+		if len(closest) >= k {
+			break
+		}
+		if c.state == candidateResponded {
+			closest = append(closest, c.peer)
+		}
+	}
+
+	return closest
+}
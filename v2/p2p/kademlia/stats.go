@@ -0,0 +1,125 @@
+package kademlia
+
+import (
+	"slices"
+
+	"github.com/elecbug/netkit/v2/p2p"
+)
+
+// Summary aggregates the hop counts and latencies of a set of lookups.
+type Summary struct {
+	Lookups int `json:"lookups"` // number of lookups
+	Found   int `json:"found"`   // number of FIND_VALUE lookups that found the value
+
+	Hops     map[int]int `json:"hops"`      // hop count -> number of lookups
+	MeanHops float64     `json:"mean_hops"` // mean hop count
+
+	LatencyMean float64 `json:"latency_mean"` // mean lookup latency, in milliseconds
+	LatencyP50  float64 `json:"latency_p50"`  // median lookup latency, in milliseconds
+	LatencyP90  float64 `json:"latency_p90"`  // 90th percentile lookup latency, in milliseconds
+	LatencyP99  float64 `json:"latency_p99"`  // 99th percentile lookup latency, in milliseconds
+	LatencyMax  float64 `json:"latency_max"`  // maximum lookup latency, in milliseconds
+
+	MeanMessages float64 `json:"mean_messages"` // mean number of RPCs per lookup
+	Timeouts     int     `json:"timeouts"`      // total number of RPCs sent to failed peers
+}
+
+// Health describes how well the routing tables of the live peers reflect the peers of the DHT.
+type Health struct {
+	Completeness float64 `json:"completeness"`  // fraction of the live contacts the buckets could hold that they do hold
+	Stale        float64 `json:"stale"`         // fraction of the contacts that are failed peers
+	MeanContacts float64 `json:"mean_contacts"` // mean number of contacts per routing table
+}
+
+// Summarize computes the hop count and latency distributions of the given lookups.
+func Summarize(results []*LookupResult) *Summary {
+	s := &Summary{Hops: make(map[int]int)}
+
+	latencies := make([]float64, 0, len(results))
+	hops, messages := 0, 0
+
+	for _, r := range results {
+		s.Lookups++
+		if r.Found {
+			s.Found++
+		}
+
+		s.Hops[r.Hops]++
+		s.Timeouts += r.Timeouts
+		s.LatencyMean += r.Latency
+
+		hops += r.Hops
+		messages += r.Messages
+		latencies = append(latencies, r.Latency)
+	}
+
+	if s.Lookups > 0 {
+		s.MeanHops = float64(hops) / float64(s.Lookups)
+		s.MeanMessages = float64(messages) / float64(s.Lookups)
+		s.LatencyMean /= float64(s.Lookups)
+	}
+
+	slices.Sort(latencies)
+
+	s.LatencyP50 = p2p.Percentile(latencies, 0.50)
+	s.LatencyP90 = p2p.Percentile(latencies, 0.90)
+	s.LatencyP99 = p2p.Percentile(latencies, 0.99)
+	s.LatencyMax = p2p.Percentile(latencies, 1)
+
+	return s
+}
+
+// Health inspects the routing tables of the live peers. For each bucket, the number of contacts it could hold is
+// the number of live peers in its range, capped at K.
+func (d *DHT) Health() *Health {
+	h := &Health{}
+
+	live := make([]*node, 0, len(d.ids))
+	for _, id := range d.ids {
+		if !d.nodes[id].failed {
+			live = append(live, d.nodes[id])
+		}
+	}
+
+	held, possible, contacts, stale := 0, 0, 0, 0
+
+	for _, n := range live {
+		inRange := make(map[int]int)
+		for _, other := range live {
+			if other != n {
+				inRange[CommonPrefixLen(n.id, other.id)]++
+			}
+		}
+
+		for i, count := range inRange {
+			possible += min(count, d.cfg.K)
+
+			for _, contact := range n.buckets[i] {
+				if !d.nodes[contact].failed {
+					held++
+				}
+			}
+		}
+
+		for i := range n.buckets {
+			for _, contact := range n.buckets[i] {
+				contacts++
+				if d.nodes[contact].failed {
+					stale++
+				}
+			}
+		}
+	}
+
+	if possible > 0 {
+		h.Completeness = float64(held) / float64(possible)
+	}
+	if contacts > 0 {
+		h.Stale = float64(stale) / float64(contacts)
+	}
+	if len(live) > 0 {
+		h.MeanContacts = float64(contacts) / float64(len(live))
+	}
+
+	return h
+}
@@ -0,0 +1,243 @@
+package p2p
+
+import (
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/elecbug/netkit/v2/graph"
+)
+
+// LatencyFunc generates the latency of a link from src to dst in milliseconds, like Config.NetworkLatencyFunc.
+type LatencyFunc = func(src PeerID, dst PeerID) float64
+
+/* Distributions */
+
+// ConstantLatency returns a latency model in which every link has the given latency in milliseconds.
+func ConstantLatency(latency float64) LatencyFunc {
+	return func(src, dst PeerID) float64 {
+		return latency
+	}
+}
+
+// UniformLatency returns a latency model that draws the latency of each link uniformly between low and high
+// milliseconds. Draws are derived from the seed and the pair of peers, so a link has the same latency in both
+// directions whatever the order in which links are created.
+func UniformLatency(low, high float64, seed uint64) LatencyFunc {
+	return func(src, dst PeerID) float64 {
+		return low + (high-low)*pairRand(seed, src, dst).Float64()
+	}
+}
+
+// NormalLatency returns a latency model that draws the latency of each link from a normal distribution with the given
+// mean and standard deviation in milliseconds, truncated at zero. Draws are seeded per pair like UniformLatency.
+func NormalLatency(mean, stddev float64, seed uint64) LatencyFunc {
+	return func(src, dst PeerID) float64 {
+		return max(mean+stddev*pairRand(seed, src, dst).NormFloat64(), 0)
+	}
+}
+
+// LogNormalLatency returns a latency model that draws the latency of each link from a log-normal distribution with the
+// given median in milliseconds and shape sigma, the standard deviation of the latency's logarithm, which produces
+// the long tail of measured Internet latencies. Draws are seeded per pair like UniformLatency.
+func LogNormalLatency(median, sigma float64, seed uint64) LatencyFunc {
+	return func(src, dst PeerID) float64 {
+		return median * math.Exp(sigma*pairRand(seed, src, dst).NormFloat64())
+	}
+}
+
+// pairRand returns a random generator derived from the seed and the unordered pair of peers.
+func pairRand(seed uint64, a, b PeerID) *rand.Rand {
+	if b < a {
+		a, b = b, a
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(a))
+	h.Write([]byte{0})
+	h.Write([]byte(b))
+
+	return rand.New(rand.NewPCG(seed, h.Sum64()))
+}
+
+/* Geometry */
+
+// DistanceLatency returns a latency model proportional to the Euclidean distance between peers, base plus perUnit
+// milliseconds per unit of distance, using the "x" and "y" tags of the nodes of g, such as those placed by
+// standard.RandomGeometricGraph and standard.WaxmanGraph. Links involving a peer that is not in g, such as a peer
+// added at runtime, get the base latency. It returns an error if a node of g has no valid coordinates.
+func DistanceLatency(g *graph.Graph, base, perUnit float64) (LatencyFunc, error) {
+	type point struct{ x, y float64 }
+
+	positions := make(map[PeerID]point)
+
+	for _, id := range g.Nodes() {
+		node, err := g.Node(id)
+		if err != nil {
+			return nil, err
+		}
+
+		var coords [2]float64
+		for i, key := range []string{"x", "y"} {
+			value, ok := node.Tag(key)
+			if !ok {
+				return nil, fmt.Errorf("node %s has no %s coordinate", id, key)
+			}
+
+			if coords[i], err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s coordinate of node %s: %v", key, id, err)
+			}
+		}
+
+		positions[PeerID(id)] = point{x: coords[0], y: coords[1]}
+	}
+
+	return func(src, dst PeerID) float64 {
+		a, okA := positions[src]
+		b, okB := positions[dst]
+		if !okA || !okB {
+			return base
+		}
+
+		return base + perUnit*math.Hypot(a.x-b.x, a.y-b.y)
+	}, nil
+}
+
+/* Regions */
+
+// RegionMatrix holds measured round-trip times between regions, in milliseconds.
+type RegionMatrix struct {
+	Regions []string             // names of the regions, in the order of the CSV header
+	RTT     map[string][]float64 // source region -> round-trip time to each region, in the order of Regions
+}
+
+// LoadRegionMatrix reads a matrix of round-trip times from CSV. The header row holds an empty or ignored first cell
+// followed by the region names, and every other row holds a region name followed by its round-trip times in
+// milliseconds to each region of the header, so that asymmetric measurements are kept per direction.
+func LoadRegionMatrix(r io.Reader) (*RegionMatrix, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read region matrix: %v", err)
+	}
+	if len(records) < 2 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("region matrix must have a header and at least one region")
+	}
+
+	m := &RegionMatrix{RTT: make(map[string][]float64)}
+
+	for _, name := range records[0][1:] {
+		m.Regions = append(m.Regions, strings.TrimSpace(name))
+	}
+
+	for _, record := range records[1:] {
+		region := strings.TrimSpace(record[0])
+		if !slices.Contains(m.Regions, region) {
+			return nil, fmt.Errorf("region %s is not in the header", region)
+		}
+		if len(record) != len(m.Regions)+1 {
+			return nil, fmt.Errorf("region %s has %d values, expected %d", region, len(record)-1, len(m.Regions))
+		}
+
+		rtts := make([]float64, len(m.Regions))
+		for i, value := range record[1:] {
+			rtt, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || rtt < 0 {
+				return nil, fmt.Errorf("invalid round-trip time from %s to %s: %s", region, m.Regions[i], value)
+			}
+
+			rtts[i] = rtt
+		}
+
+		m.RTT[region] = rtts
+	}
+
+	for _, region := range m.Regions {
+		if _, ok := m.RTT[region]; !ok {
+			return nil, fmt.Errorf("region %s has no row", region)
+		}
+	}
+
+	return m, nil
+}
+
+// AssignRegions assigns every peer to a region of the matrix at random with the seed, with probabilities proportional
+// to the given weights, or uniformly if weights is nil. Regions without a weight are never chosen.
+// It returns an error if a weight is negative or if no region of the matrix has a positive weight.
+func (m *RegionMatrix) AssignRegions(ids []PeerID, weights map[string]float64, seed uint64) (map[PeerID]string, error) {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+
+	total := 0.0
+	for _, region := range m.Regions {
+		if weights == nil {
+			total++
+		} else if weights[region] < 0 {
+			return nil, fmt.Errorf("weight of region %s must be non-negative", region)
+		} else {
+			total += weights[region]
+		}
+	}
+
+	if total <= 0 {
+		return nil, fmt.Errorf("at least one region must have a positive weight")
+	}
+
+	rng := newRand(seed)
+	assignment := make(map[PeerID]string, len(sorted))
+
+	for _, id := range sorted {
+		x := rng.Float64() * total
+
+		for _, region := range m.Regions {
+			w := 1.0
+			if weights != nil {
+				w = weights[region]
+			}
+			if w == 0 {
+				continue
+			}
+
+			assignment[id] = region
+			if x < w {
+				break
+			}
+			x -= w
+		}
+	}
+
+	return assignment, nil
+}
+
+// Latency returns a latency model in which a link takes half the round-trip time from the region of src to the
+// region of dst. Links involving a peer without a known region take half the mean round-trip time of the matrix.
+func (m *RegionMatrix) Latency(regionOf map[PeerID]string) LatencyFunc {
+	index := make(map[string]int, len(m.Regions))
+	for i, region := range m.Regions {
+		index[region] = i
+	}
+
+	mean, count := 0.0, 0
+	for _, rtts := range m.RTT {
+		for _, rtt := range rtts {
+			mean += rtt
+			count++
+		}
+	}
+	mean /= float64(count)
+
+	return func(src, dst PeerID) float64 {
+		rtts, okSrc := m.RTT[regionOf[src]]
+		i, okDst := index[regionOf[dst]]
+		if !okSrc || !okDst {
+			return mean / 2
+		}
+
+		return rtts[i] / 2
+	}
+}
@@ -0,0 +1,100 @@
+package p2p
+
+import "fmt"
+
+// OverflowPolicy selects what happens when a message is delivered to a peer whose message queue is full.
+type OverflowPolicy string
+
+const (
+	OverflowBlock      OverflowPolicy = ""            // the sender waits until the queue has room or the peer is stopped
+	OverflowDropTail   OverflowPolicy = "drop_tail"   // the incoming message is dropped
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // the oldest queued message is dropped to make room for the incoming one
+)
+
+// defaultQueueCapacity is the capacity of a peer's message queue when Config.QueueCapacity is not set.
+const defaultQueueCapacity = 1000
+
+// enqueue puts a message into the peer's message queue according to the overflow policy.
+// Messages delivered to a stopped peer are discarded without being counted as dropped.
+func (p *peer) enqueue(msg Message, policy OverflowPolicy) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	switch policy {
+	case OverflowDropTail:
+		select {
+		case p.msgQueue <- msg:
+		default:
+			p.countDrop()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case p.msgQueue <- msg:
+				return
+			case <-p.done:
+				return
+			default:
+			}
+
+			select {
+			case <-p.msgQueue:
+				p.countDrop()
+			default:
+			}
+		}
+	default:
+		select {
+		case p.msgQueue <- msg:
+		case <-p.done:
+		}
+	}
+}
+
+// countDrop increments the peer's counter of messages dropped on a full queue.
+func (p *peer) countDrop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dropped++
+}
+
+// DroppedMessages returns the number of messages the specified peer dropped because its message queue was full.
+func (p *P2P) DroppedMessages(id PeerID) (int, error) {
+	peer, ok := p.peer(id)
+	if !ok {
+		return 0, fmt.Errorf("peer %s not found", id)
+	}
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	return peer.dropped, nil
+}
+
+// DropCounts returns the number of messages dropped on a full queue for every peer that dropped at least one.
+func (p *P2P) DropCounts() map[PeerID]int {
+	counts := make(map[PeerID]int)
+
+	for _, peer := range p.peerList() {
+		peer.mu.Lock()
+		if peer.dropped > 0 {
+			counts[peer.id] = peer.dropped
+		}
+		peer.mu.Unlock()
+	}
+
+	return counts
+}
+
+// queueCapacity returns the configured message queue capacity, or the default if it is not set.
+func queueCapacity(capacity int) int {
+	if capacity <= 0 {
+		return defaultQueueCapacity
+	}
+
+	return capacity
+}
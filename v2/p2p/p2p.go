@@ -3,8 +3,10 @@ package p2p
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elecbug/netkit/v2/graph"
@@ -15,23 +17,191 @@ type PeerID graph.NodeID
 
 // P2P represents a peer-to-peer network, containing a map of peers and the configuration for the network.
 type P2P struct {
-	peers map[PeerID]*peer
-	cfg   *Config
+	peers     map[PeerID]*peer
+	cfg       *Config
+	sched     *scheduler              // discrete-event scheduler, nil unless the network runs on a simulated clock
+	transport *socketTransport        // socket transport carrying messages between peers, nil for the in-memory transport
+	rng       *rand.Rand              // random source seeded from Config.Seed, shared by all peers and protocols
+	protocols map[string]ProtocolFunc // protocols known by name: the built-in ones and Config.NamedProtocols
+
+	directed  bool                   // indicates whether links are one-way, as in the source graph
+	running   bool                   // indicates whether Run has been called, so that added peers start immediately
+	ctx       context.Context        // context passed to Run, used to start peers added at runtime
+	start     atomic.Int64           // start of the run in Unix nanoseconds, used to compute elapsed times of trace events
+	triggers  []*trigger             // pending failure actions fired by reception events
+	published map[string]publication // message ID -> publisher and publish time
+	workErr   error                  // first error of a workload publication, reported by WorkloadError
+	workIDs   int                    // number of message IDs generated by workloads, which continue from it
+	traceErr  error                  // first error returned by Config.TraceWriter, reported by TraceError
+	traceMu   sync.Mutex             // mutex to serialize the calls to Config.TraceWriter
+	mu        sync.RWMutex           // mutex to protect access to the peer map and the network-wide state
 }
 
+// WeightMode selects how the edge weights of a weighted source graph are used by the P2P network.
+type WeightMode string
+
+const (
+	WeightRejected    WeightMode = ""          // weighted graphs are rejected
+	WeightIgnored     WeightMode = "ignored"   // edge weights are ignored and NetworkLatencyFunc generates every latency
+	WeightAsLatency   WeightMode = "latency"   // edge weights, multiplied by WeightScale, become network latencies in milliseconds
+	WeightAsBandwidth WeightMode = "bandwidth" // edge weights, multiplied by WeightScale, become link bandwidths in megabits per second
+)
+
 // Config holds configuration parameters for the P2P network, including functions to generate processing and network latencies.
 type Config struct {
 	// ProcessingLatencyFunc generates the latency for processing a message at the source peer. It should return the latency in milliseconds.
 	ProcessingLatencyFunc func(src PeerID) float64
 	// NetworkLatencyFunc generates the latency for a message sent from src to dst. It should return the latency in milliseconds.
+	// It may be nil if WeightMode is WeightAsLatency and no links are added with Connect.
 	NetworkLatencyFunc func(src PeerID, dst PeerID) float64
+	// WeightMode selects how the edge weights of a weighted source graph are used. By default weighted graphs are rejected.
+	WeightMode WeightMode
+	// WeightScale is the factor applied to edge weights when WeightMode is WeightAsLatency or WeightAsBandwidth. Zero means a factor of 1.
+	WeightScale float64
+	// UploadBandwidthFunc generates the upload bandwidth of a peer in megabits per second. A peer transmits one message at a time,
+	// so sending a message of Message.Size bytes to many neighbors queues the transmissions. Nil or non-positive means unlimited.
+	UploadBandwidthFunc func(id PeerID) float64
+	// DownloadBandwidthFunc generates the download bandwidth of a peer in megabits per second. A peer receives one message at a time,
+	// so concurrently arriving messages queue on its download link. Nil or non-positive means unlimited.
+	DownloadBandwidthFunc func(id PeerID) float64
+	// SimulatedClock runs the network on a virtual clock driven by a discrete-event scheduler instead of goroutines and time.Sleep.
+	// Latencies advance virtual time, Publish only schedules events, and RunUntilQuiescent executes them.
+	SimulatedClock bool
+	// QueueCapacity is the capacity of each peer's message queue. Zero means a capacity of 1000.
+	// Queues are only used without a simulated clock, where messages are handled as soon as they are delivered.
+	QueueCapacity int
+	// OverflowPolicy selects what happens when a message is delivered to a full queue. By default the sender blocks.
+	OverflowPolicy OverflowPolicy
+	// AntiEntropy, if set, makes every peer run periodic anti-entropy rounds with its neighbors.
+	AntiEntropy *AntiEntropyConfig
+	// Announce, if set, makes peers announce the messages they forward and send them only on request.
+	Announce *AnnounceConfig
+	// GossipSub, if set, makes peers disseminate topic messages along GossipSub-style meshes.
+	GossipSub *GossipSubConfig
+	// Plumtree, if set, makes peers disseminate messages published with a nil protocol along epidemic broadcast trees.
+	// It cannot be combined with GossipSub, which uses the same control messages.
+	Plumtree *PlumtreeConfig
+	// Dandelion, if set, makes peers relay messages published with Message.Stem along Dandelion++ stems before fluffing them.
+	Dandelion *DandelionConfig
+	// Protocol, if set, creates the stateful protocol instance of each peer, which sees every message the peer handles
+	// and forwards the messages published with a nil protocol. It cannot be combined with Plumtree, which also
+	// forwards those messages. FuncProtocol adapts a ProtocolFunc such as Flooding or Gossip.
+	Protocol func(id PeerID) Protocol
+	// BehaviorFunc, if set, returns the misbehavior of each peer, or nil for an honest peer.
+	BehaviorFunc func(id PeerID) Behavior
+	// Transport selects how messages travel between peers. By default they are handed over in memory; with TransportTCP or
+	// TransportUDP every peer listens on a loopback port and messages are serialized and sent after their network latency,
+	// so that real kernel networking adds to the simulated latencies. Socket transports cannot run on a simulated clock,
+	// and their messages must name their protocol with Message.ProtocolName.
+	Transport Transport
+	// NamedProtocols registers protocols under unique names, so that messages can name their protocol with
+	// Message.ProtocolName, as required over socket transports. Flooding, Gossip and Pull are always registered as
+	// "flooding", "gossip" and "pull".
+	NamedProtocols map[string]ProtocolFunc
+	// TraceWriter, if set, receives every send and receive event as it is recorded. Its first error is reported by TraceError.
+	TraceWriter TraceWriter
+	// Seed initializes the network's random source, which is handed to every ProtocolFunc through Message.Rand.
+	// Runs over the same topology with the same Seed make the same random choices.
+	Seed uint64
+}
+
+// CheckQuiescence returns an error if a built-in protocol enabled by the configuration acts on periodic timers
+// without a limit, such as anti-entropy rounds, GossipSub heartbeats or Dandelion epochs, so that a network never
+// becomes quiescent.
+// Timers set by a stateful Protocol are not checked.
+func (c *Config) CheckQuiescence() error {
+	if c.AntiEntropy != nil && c.AntiEntropy.Rounds == 0 {
+		return fmt.Errorf("anti-entropy rounds are unlimited")
+	}
+	if c.GossipSub != nil && c.GossipSub.Heartbeats == 0 {
+		return fmt.Errorf("gossipsub heartbeats are unlimited")
+	}
+	if c.Dandelion != nil && c.Dandelion.EpochLength > 0 && c.Dandelion.Epochs == 0 {
+		return fmt.Errorf("dandelion epochs are unlimited")
+	}
+
+	return nil
 }
 
 // New creates a new P2P network from the given graph. It returns an error if the graph is weighted,
-// as weighted graphs are not supported for P2P generation.
+// unless Config.WeightMode selects how edge weights are used.
 func New(source *graph.Graph, cfg *Config) (*P2P, error) {
-	if source.IsWeighted() {
-		return nil, fmt.Errorf("weighted graphs are not supported for P2P generation")
+	switch cfg.WeightMode {
+	case WeightRejected:
+		if source.IsWeighted() {
+			return nil, fmt.Errorf("weighted graphs are not supported for P2P generation without a weight mode")
+		}
+	case WeightIgnored, WeightAsLatency, WeightAsBandwidth:
+	default:
+		return nil, fmt.Errorf("unsupported weight mode: %s", cfg.WeightMode)
+	}
+
+	switch cfg.OverflowPolicy {
+	case OverflowBlock, OverflowDropTail, OverflowDropOldest:
+	default:
+		return nil, fmt.Errorf("unsupported overflow policy: %s", cfg.OverflowPolicy)
+	}
+
+	if cfg.AntiEntropy != nil {
+		if cfg.AntiEntropy.Interval <= 0 {
+			return nil, fmt.Errorf("anti-entropy interval must be positive")
+		}
+
+		switch cfg.AntiEntropy.Mode {
+		case AntiEntropyPushPull, AntiEntropyPull, AntiEntropyPush:
+		default:
+			return nil, fmt.Errorf("unsupported anti-entropy mode: %s", cfg.AntiEntropy.Mode)
+		}
+	}
+
+	if cfg.Announce != nil && (cfg.Announce.AnnounceSize < 0 || cfg.Announce.RequestSize < 0 || cfg.Announce.RequestTimeout < 0) {
+		return nil, fmt.Errorf("announce sizes and request timeout must be non-negative")
+	}
+
+	if cfg.GossipSub != nil {
+		if err := cfg.GossipSub.withDefaults().validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Plumtree != nil {
+		if cfg.GossipSub != nil {
+			return nil, fmt.Errorf("plumtree cannot be combined with gossipsub")
+		}
+		if cfg.Protocol != nil {
+			return nil, fmt.Errorf("plumtree cannot be combined with a stateful protocol")
+		}
+		if cfg.Plumtree.GraftTimeout <= 0 || cfg.Plumtree.GraftRetry < 0 || cfg.Plumtree.LazyDelay < 0 {
+			return nil, fmt.Errorf("plumtree graft timeout must be positive, graft retry and lazy delay non-negative")
+		}
+	}
+
+	if cfg.Dandelion != nil {
+		if cfg.Dandelion.FluffProbability < 0 || cfg.Dandelion.FluffProbability > 1 {
+			return nil, fmt.Errorf("dandelion fluff probability must be between 0 and 1")
+		}
+		if cfg.Dandelion.Relays < 0 || cfg.Dandelion.EpochLength < 0 || cfg.Dandelion.Epochs < 0 || cfg.Dandelion.EmbargoTimeout < 0 {
+			return nil, fmt.Errorf("dandelion relays, epoch length, epochs and embargo timeout must be non-negative")
+		}
+	}
+
+	switch cfg.Transport {
+	case TransportMemory:
+	case TransportTCP, TransportUDP:
+		if cfg.SimulatedClock {
+			return nil, fmt.Errorf("%s transport cannot run on a simulated clock", cfg.Transport)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transport: %s", cfg.Transport)
+	}
+
+	if cfg.WeightMode != WeightAsLatency && cfg.NetworkLatencyFunc == nil {
+		return nil, fmt.Errorf("network latency function is required unless edge weights are used as latencies")
+	}
+
+	protocols, err := newRegistry(cfg.NamedProtocols)
+	if err != nil {
+		return nil, err
 	}
 
 	nodes := make(map[PeerID]*peer)
@@ -39,8 +209,11 @@ func New(source *graph.Graph, cfg *Config) (*P2P, error) {
 
 	// create nodes
 	for _, gn := range source.Nodes() {
-		n := newPeer(PeerID(gn), cfg.ProcessingLatencyFunc(PeerID(gn)))
+		n := newPeer(PeerID(gn), cfg.ProcessingLatencyFunc(PeerID(gn)), queueCapacity(cfg.QueueCapacity))
 		n.edges = make(map[PeerID]edge)
+		n.uploadBandwidth = bandwidthOf(cfg.UploadBandwidthFunc, n.id)
+		n.downloadBandwidth = bandwidthOf(cfg.DownloadBandwidthFunc, n.id)
+		n.behavior = behaviorOf(cfg.BehaviorFunc, n.id)
 
 		nodes[n.id] = n
 		maps[gn] = n.id
@@ -54,51 +227,165 @@ func New(source *graph.Graph, cfg *Config) (*P2P, error) {
 			return nil, fmt.Errorf("failed to get node %s from graph: %v", gn, err)
 		}
 
-		for _, neighbor := range node.Neighbors() {
+		neighbors := node.Neighbors()
+		slices.Sort(neighbors)
+
+		for _, neighbor := range neighbors {
 			j := maps[neighbor]
 
+			var latency, bandwidth float64
+
+			if cfg.WeightMode == WeightAsLatency || cfg.WeightMode == WeightAsBandwidth {
+				weight, err := source.EdgeWeight(gn, neighbor)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get weight of edge from %s to %s: %v", gn, neighbor, err)
+				}
+
+				if cfg.WeightMode == WeightAsLatency {
+					latency = float64(weight) * weightScale(cfg.WeightScale)
+				} else {
+					bandwidth = float64(weight) * weightScale(cfg.WeightScale)
+				}
+			}
+
+			if cfg.WeightMode != WeightAsLatency {
+				latency = cfg.NetworkLatencyFunc(PeerID(gn), PeerID(j))
+			}
+
 			edge := edge{
 				targetID:       PeerID(j),
-				networkLatency: cfg.NetworkLatencyFunc(PeerID(gn), PeerID(j)),
+				networkLatency: latency,
+				bandwidth:      bandwidth,
 			}
 
 			n.edges[edge.targetID] = edge
 		}
 	}
 
-	return &P2P{peers: nodes, cfg: cfg}, nil
+	network := &P2P{
+		peers:     nodes,
+		cfg:       cfg,
+		rng:       newRand(cfg.Seed),
+		protocols: protocols,
+		directed:  source.IsDirected(),
+		published: make(map[string]publication),
+	}
+
+	if cfg.SimulatedClock {
+		network.sched = newScheduler()
+	}
+
+	for _, n := range nodes {
+		n.handlers = network.newHandlers(n.id)
+	}
+
+	if cfg.Transport != TransportMemory {
+		network.transport = newSocketTransport(string(cfg.Transport))
+
+		for _, n := range network.peerList() {
+			if err := network.transport.open(network, n.id); err != nil {
+				network.transport.closeAll()
+				return nil, err
+			}
+		}
+	}
+
+	network.start.Store(network.now().UnixNano())
+
+	return network, nil
 }
 
 // Free clears all peers from the P2P network, effectively resetting it to an empty state.
 func (p *P2P) Free() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	for id := range p.peers {
 		p.peers[id].eachStop()
 		delete(p.peers, id)
 	}
+
+	if p.transport != nil {
+		p.transport.closeAll()
+	}
 }
 
 /* Basic Actions */
 
-// Run starts the message handling routines for all peers in the network.
+// Run starts the message handling routines for all peers in the network, along with the built-in protocols
+// enabled in the configuration. With a simulated clock no routines are started; the peers are only marked alive
+// and messages are processed by RunUntilQuiescent or RunFor.
 func (p *P2P) Run(ctx context.Context) {
-	wg := &sync.WaitGroup{}
-	wg.Add(len(p.peers))
+	p.mu.Lock()
+	p.running = true
+	p.ctx = ctx
+	p.mu.Unlock()
+
+	if p.sched == nil {
+		p.start.Store(time.Now().UnixNano())
+	}
+
+	if p.sched != nil {
+		for _, peer := range p.peerList() {
+			peer.mu.Lock()
+			peer.alive = true
+			peer.mu.Unlock()
+		}
+	} else {
+		peers := p.peerList()
+
+		wg := &sync.WaitGroup{}
+		wg.Add(len(peers))
 
-	for _, peer := range p.peers {
-		peer.eachRun(p, wg, ctx)
+		for _, peer := range peers {
+			peer.eachRun(p, wg, ctx)
+		}
+
+		wg.Wait()
+
+		if p.transport != nil {
+			go func() {
+				<-ctx.Done()
+				p.transport.closeAll()
+			}()
+		}
 	}
 
-	wg.Wait()
+	for _, peer := range p.peerList() {
+		peer.startHandlers(p)
+	}
 }
 
 // ExpireSimulation runs the simulation until the reachability of the specified message stabilizes or a timeout occurs.
+// It polls wall-clock time and is intended for networks without a simulated clock, which use RunUntilQuiescent instead.
 func (p *P2P) ExpireSimulation(cancel context.CancelFunc, msg string, expirationDuration, timeoutDuration, checkInterval time.Duration) {
+	p.expire(cancel, func() float64 {
+		return p.Reachability(msg)
+	}, expirationDuration, timeoutDuration, checkInterval)
+}
+
+// ExpireWorkload runs the simulation like ExpireSimulation, until the total reachability of every message published
+// so far stabilizes or a timeout occurs, so that a whole workload of concurrent messages can be awaited.
+func (p *P2P) ExpireWorkload(cancel context.CancelFunc, expirationDuration, timeoutDuration, checkInterval time.Duration) {
+	p.expire(cancel, func() float64 {
+		total := 0.0
+		for _, msg := range p.Messages() {
+			total += p.Reachability(msg)
+		}
+
+		return total
+	}, expirationDuration, timeoutDuration, checkInterval)
+}
+
+// expire polls the progress until it has not grown for expirationDuration or timeoutDuration has passed,
+// then stops every peer and cancels the run.
+func (p *P2P) expire(cancel context.CancelFunc, progress func() float64, expirationDuration, timeoutDuration, checkInterval time.Duration) {
 	startTime := time.Now()
 	lastChangeTime := startTime
-	beforeRch := p.Reachability(msg)
+	beforeRch := progress()
 
 	for {
-		currentRch := p.Reachability(msg)
+		currentRch := progress()
 
 		if currentRch > beforeRch {
 			beforeRch = currentRch
@@ -115,17 +402,62 @@ func (p *P2P) ExpireSimulation(cancel context.CancelFunc, msg string, expiration
 		time.Sleep(checkInterval)
 	}
 
-	for _, peer := range p.peers {
+	for _, peer := range p.peerList() {
 		peer.eachStop()
 	}
 
 	cancel()
 }
 
+// RunUntilQuiescent executes scheduled events in virtual-time order until none remain,
+// and returns the virtual time elapsed since the start of the simulation.
+// It returns an error if the network does not run on a simulated clock, or if a built-in protocol acts on
+// unbounded periodic timers, as reported by Config.CheckQuiescence, in which case RunFor must be used instead.
+func (p *P2P) RunUntilQuiescent() (time.Duration, error) {
+	if p.sched == nil {
+		return 0, fmt.Errorf("network does not run on a simulated clock")
+	}
+	if err := p.cfg.CheckQuiescence(); err != nil {
+		return 0, fmt.Errorf("%v; use RunFor instead", err)
+	}
+
+	for p.sched.step() {
+	}
+
+	return p.sched.elapsed(), nil
+}
+
+// RunFor executes scheduled events in virtual-time order until the given duration of virtual time has passed,
+// and returns the virtual time elapsed since the start of the simulation. Unlike RunUntilQuiescent, it also
+// terminates when peers act on periodic timers, such as anti-entropy rounds without a round limit.
+// It returns an error if the network does not run on a simulated clock.
+func (p *P2P) RunFor(d time.Duration) (time.Duration, error) {
+	if p.sched == nil {
+		return 0, fmt.Errorf("network does not run on a simulated clock")
+	}
+
+	p.sched.runUntil(p.sched.elapsed() + d)
+
+	return p.sched.elapsed(), nil
+}
+
+// Now returns the current time of the network: the virtual time with a simulated clock, or the wall-clock time otherwise.
+func (p *P2P) Now() time.Time {
+	return p.now()
+}
+
 /* Network Information */
 
+// Rand returns the network's seeded random source, which is safe for concurrent use.
+func (p *P2P) Rand() *rand.Rand {
+	return p.rng
+}
+
 // PeerIDs returns a slice of all node IDs in the network.
 func (p *P2P) PeerIDs() []PeerID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	ids := make([]PeerID, 0, len(p.peers))
 
 	for id := range p.peers {
@@ -141,20 +473,63 @@ func (p *P2P) PeerIDs() []PeerID {
 
 // Publish sends a message to the specified peer's message queue.
 func (p *P2P) Publish(id PeerID, msg string, protocol ProtocolFunc, staticParams, dynamicParams map[string]any) error {
-	if peer, ok := p.peers[id]; ok {
-		if !peer.alive {
+	return p.PublishMessage(id, Message{
+		Content:       msg,
+		Protocol:      protocol,
+		StaticParams:  staticParams,
+		DynamicParams: dynamicParams,
+	})
+}
+
+// PublishMessage publishes a message from the specified peer. The publisher, sender, hop count and random source
+// of the message are set by the network; the remaining fields, such as Size, are taken from msg. If msg.ID is empty,
+// the content is used as the ID, so that every message must have a unique content unless it is given its own ID.
+// It returns an error if the static parameters, such as the gossip factor or the parameters set by PublishTyped, are
+// invalid, if ProtocolName is not registered, if the hop limit or TTL is negative, or if a message with the same ID
+// was already published, since peers would drop it as a duplicate.
+func (p *P2P) PublishMessage(id PeerID, msg Message) error {
+	if peer, ok := p.peer(id); ok {
+		if !peer.isAlive() {
 			return fmt.Errorf("peer %s is not alive", id)
 		}
 
-		peer.msgQueue <- Message{
-			Publisher:     id,
-			From:          id,
-			Content:       msg,
-			Protocol:      protocol,
-			StaticParams:  staticParams,
-			DynamicParams: dynamicParams,
-			HopCount:      0,
+		if msg.ID == "" {
+			msg.ID = msg.Content
+		}
+
+		if msg.MaxHops < 0 || msg.TTL < 0 {
+			return fmt.Errorf("hop limit and TTL of message %s must be non-negative", msg.ID)
 		}
+
+		if msg.ProtocolName != "" {
+			protocol, ok := p.protocols[msg.ProtocolName]
+			if !ok {
+				return fmt.Errorf("protocol %s of message %s is not registered", msg.ProtocolName, msg.ID)
+			}
+
+			msg.Protocol = protocol
+		} else if p.transport != nil && msg.Protocol != nil {
+			return fmt.Errorf("message %s must name its protocol with ProtocolName to be sent over the %s transport", msg.ID, p.cfg.Transport)
+		}
+
+		if err := validateStatic(msg.StaticParams); err != nil {
+			return fmt.Errorf("invalid parameters for message %s: %v", msg.ID, err)
+		}
+
+		p.mu.Lock()
+		if _, ok := p.published[msg.ID]; ok {
+			p.mu.Unlock()
+			return fmt.Errorf("message %s was already published", msg.ID)
+		}
+		p.published[msg.ID] = publication{publisher: id, at: p.now(), maxHops: msg.MaxHops}
+		p.mu.Unlock()
+
+		msg.Publisher = id
+		msg.From = id
+		msg.HopCount = 0
+		msg.Rand = p.rng
+
+		p.deliver(peer, msg)
 		return nil
 	}
 
@@ -166,7 +541,7 @@ func (p *P2P) Reachability(msg string) float64 {
 	total := 0
 	reached := 0
 
-	for _, peer := range p.peers {
+	for _, peer := range p.peerList() {
 		total++
 		peer.mu.Lock()
 		if _, ok := peer.seenAt[msg]; ok {
@@ -182,7 +557,7 @@ func (p *P2P) Reachability(msg string) float64 {
 func (p *P2P) FirstMessageReceptionTimes(msg string) []time.Time {
 	firstTimes := make([]time.Time, 0)
 
-	for _, peer := range p.peers {
+	for _, peer := range p.peerList() {
 		peer.mu.Lock()
 		if t, ok := peer.seenAt[msg]; ok {
 			firstTimes = append(firstTimes, t)
@@ -195,29 +570,15 @@ func (p *P2P) FirstMessageReceptionTimes(msg string) []time.Time {
 }
 
 // FirstMessageReceptions returns the first reception details of the specified message across all peers, including the peer ID, the sender's peer ID, and the timestamp.
-func (p *P2P) FirstMessageReceptions(msg string) []struct {
-	PeerID    PeerID    `json:"peer_id"`
-	From      PeerID    `json:"from"`
-	Timestamp time.Time `json:"timestamp"`
-} {
-	receptions := make([]struct {
-		PeerID    PeerID    `json:"peer_id"`
-		From      PeerID    `json:"from"`
-		Timestamp time.Time `json:"timestamp"`
-	}, 0)
-
-	for _, peer := range p.peers {
+func (p *P2P) FirstMessageReceptions(msg string) []Reception {
+	receptions := make([]Reception, 0)
+
+	for _, peer := range p.peerList() {
 		peer.mu.Lock()
 		if t, ok := peer.seenAt[msg]; ok {
-			from := peer.firstFrom[msg]
-
-			receptions = append(receptions, struct {
-				PeerID    PeerID    `json:"peer_id"`
-				From      PeerID    `json:"from"`
-				Timestamp time.Time `json:"timestamp"`
-			}{
+			receptions = append(receptions, Reception{
 				PeerID:    peer.id,
-				From:      from,
+				From:      peer.firstFrom[msg],
 				Timestamp: t,
 			})
 		}
@@ -231,7 +592,7 @@ func (p *P2P) FirstMessageReceptions(msg string) []struct {
 func (p *P2P) DuplicateMessageCount(msg string) int {
 	dupCount := 0
 
-	for _, peer := range p.peers {
+	for _, peer := range p.peerList() {
 		peer.mu.Lock()
 		if count, ok := peer.recvFrom[msg]; ok {
 			dupCount += len(count) - 1
@@ -242,11 +603,11 @@ func (p *P2P) DuplicateMessageCount(msg string) int {
 	return dupCount
 }
 
-// MessageInfo returns a snapshot of the peer's message-related information.
-func (p *P2P) MessageInfo(peerID PeerID, content string) (map[string]any, error) {
-	peer := p.peers[peerID]
+// MessageInfo returns a snapshot of the peer's information about the message with the specified ID.
+func (p *P2P) MessageInfo(peerID PeerID, msg string) (map[string]any, error) {
+	peer, ok := p.peer(peerID)
 
-	if peer == nil {
+	if !ok {
 		return nil, fmt.Errorf("peer %s not found", peerID)
 	}
 
@@ -256,36 +617,91 @@ func (p *P2P) MessageInfo(peerID PeerID, content string) (map[string]any, error)
 	info := make(map[string]any)
 
 	info["recv"] = make([]PeerID, 0)
-	for k := range peer.recvFrom[content] {
+	for k := range peer.recvFrom[msg] {
 		info["recv"] = append(info["recv"].([]PeerID), k)
 	}
 
 	info["sent"] = make([]PeerID, 0)
-	for k := range peer.sentTo[content] {
+	for k := range peer.sentTo[msg] {
 		info["sent"] = append(info["sent"].([]PeerID), k)
 	}
 
-	info["seen"] = peer.seenAt[content].String()
-	info["first_from"] = peer.firstFrom[content]
+	info["seen"] = peer.seenAt[msg].String()
+	info["first_from"] = peer.firstFrom[msg]
 
 	return info, nil
 }
 
-// PeerLog returns a copy of the log entries for the specified peer, allowing for inspection of message flow and events.
-func (p *P2P) PeerLog(peerID PeerID, content string) (map[string][]logEntry, error) {
-	peer := p.peers[peerID]
+// PeerLog returns a copy of the trace events recorded by the specified peer, keyed by message ID,
+// allowing for inspection of message flow and events.
+func (p *P2P) PeerLog(peerID PeerID, content string) (map[string][]TraceEvent, error) {
+	peer, ok := p.peer(peerID)
 
-	if peer == nil {
+	if !ok {
 		return nil, fmt.Errorf("peer %s not found", peerID)
 	}
 
 	peer.mu.Lock()
 	defer peer.mu.Unlock()
 
-	logCopy := make(map[string][]logEntry)
+	logCopy := make(map[string][]TraceEvent)
 	for k, v := range peer.log {
-		logCopy[k] = append([]logEntry(nil), v...)
+		logCopy[k] = append([]TraceEvent(nil), v...)
 	}
 
 	return logCopy, nil
 }
+
+/* Clock */
+
+// now returns the current virtual time with a simulated clock, or the wall-clock time otherwise.
+func (p *P2P) now() time.Time {
+	if p.sched != nil {
+		return simulationEpoch.Add(p.sched.elapsed())
+	}
+
+	return time.Now()
+}
+
+// after runs fn once the given latency in milliseconds has passed, in virtual or wall-clock time.
+func (p *P2P) after(latency float64, fn func()) {
+	p.afterDuration(milliseconds(latency), fn)
+}
+
+// afterDuration runs fn once the given delay has passed, in virtual or wall-clock time.
+func (p *P2P) afterDuration(delay time.Duration, fn func()) {
+	if p.sched != nil {
+		p.sched.schedule(delay, fn)
+		return
+	}
+
+	go func() {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		fn()
+	}()
+}
+
+// deliver hands a message to the target peer, either through its message queue or,
+// with a simulated clock, by scheduling its handling at the current virtual time.
+func (p *P2P) deliver(target *peer, msg Message) {
+	if p.sched != nil {
+		p.sched.schedule(0, func() {
+			target.handle(p, msg)
+		})
+		return
+	}
+
+	target.enqueue(msg, p.cfg.OverflowPolicy)
+}
+
+// weightScale returns the factor applied to edge weights, treating zero as a factor of 1.
+func weightScale(scale float64) float64 {
+	if scale == 0 {
+		return 1
+	}
+
+	return scale
+}
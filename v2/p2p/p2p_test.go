@@ -0,0 +1,2420 @@
+package p2p_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elecbug/netkit/v2/graph"
+	"github.com/elecbug/netkit/v2/graph/analyzer"
+	"github.com/elecbug/netkit/v2/graph/standard"
+	"github.com/elecbug/netkit/v2/p2p"
+)
+
+// TestSimulatedClock verifies that a network running on a simulated clock delivers messages in virtual time,
+// so that reception times are exact and identical across runs.
+func TestSimulatedClock(t *testing.T) {
+	fmt.Println("Test Simulated Clock")
+
+	line := lineGraph(t, 4)
+
+	cfg := &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		SimulatedClock:        true,
+	}
+
+	nw, err := p2p.New(line, cfg)
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+
+	elapsed, err := nw.RunUntilQuiescent()
+	if err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	if elapsed != 55*time.Millisecond {
+		t.Fatalf("expected simulation to end at 55ms, got %v", elapsed)
+	}
+
+	if r := nw.Reachability("msg"); r != 1 {
+		t.Fatalf("expected reachability 1, got %f", r)
+	}
+
+	start := nw.FirstMessageReceptions("msg")
+	for _, rec := range start {
+		var hop int
+		fmt.Sscanf(string(rec.PeerID), "%d", &hop)
+
+		expected := time.Duration(hop*15) * time.Millisecond
+		if got := rec.Timestamp.Sub(time.Unix(0, 0)); got != expected {
+			t.Fatalf("expected peer %s to receive at %v, got %v", rec.PeerID, expected, got)
+		}
+	}
+
+	fmt.Println("- Test reproducibility")
+
+	g, err := standard.ErdosRenyiGraph(7, false, nil, 300, 0.03)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	first := floodTimes(t, g)
+	second := floodTimes(t, g)
+
+	if !slices.Equal(first, second) {
+		t.Fatalf("expected identical reception times across runs")
+	}
+
+	wall, err := p2p.New(line, &p2p.Config{
+		ProcessingLatencyFunc: cfg.ProcessingLatencyFunc,
+		NetworkLatencyFunc:    cfg.NetworkLatencyFunc,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	if _, err := wall.RunUntilQuiescent(); err == nil {
+		t.Fatalf("expected error running a wall-clock network until quiescent, got nil")
+	}
+}
+
+// floodTimes floods a message over the graph on a simulated clock and returns the sorted reception times.
+func floodTimes(t *testing.T, g *graph.Graph) []time.Time {
+	nw, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return float64(len(src)) },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return float64(len(src)+len(dst)) * 1.5 },
+		SimulatedClock:        true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.Publish(nw.PeerIDs()[0], "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	times := nw.FirstMessageReceptionTimes("msg")
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+	return times
+}
+
+// lineGraph builds an undirected path graph 0 - 1 - ... - (n-1).
+func lineGraph(t *testing.T, n int) *graph.Graph {
+	g := graph.New(false, false)
+
+	for i := 0; i < n; i++ {
+		if err := g.AddNode(graph.NodeID(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+
+	for i := 0; i+1 < n; i++ {
+		if err := g.AddEdge(graph.NodeID(fmt.Sprintf("%d", i)), graph.NodeID(fmt.Sprintf("%d", i+1)), nil); err != nil {
+			t.Fatalf("failed to add edge: %v", err)
+		}
+	}
+
+	return g
+}
+
+// TestSeededGossip verifies that Gossip builds the same dissemination tree for the same seed.
+func TestSeededGossip(t *testing.T) {
+	fmt.Println("Test Seeded Gossip")
+
+	g, err := standard.ErdosRenyiGraph(3, false, nil, 300, 0.05)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	first := gossipParents(t, g, 11)
+	second := gossipParents(t, g, 11)
+	other := gossipParents(t, g, 12)
+
+	if !maps.Equal(first, second) {
+		t.Fatalf("expected identical dissemination trees for the same seed")
+	}
+
+	if maps.Equal(first, other) {
+		t.Fatalf("expected different dissemination trees for different seeds")
+	}
+}
+
+// gossipParents gossips a message on a simulated clock and returns the first sender of each peer.
+func gossipParents(t *testing.T, g *graph.Graph, seed uint64) map[p2p.PeerID]p2p.PeerID {
+	nw, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 20 },
+		SimulatedClock:        true,
+		Seed:                  seed,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.Publish(nw.PeerIDs()[0], "msg", p2p.Gossip, map[string]any{"gossip_factor": 0.4}, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	parents := make(map[p2p.PeerID]p2p.PeerID)
+	for _, rec := range nw.FirstMessageReceptions("msg") {
+		parents[rec.PeerID] = rec.From
+	}
+
+	return parents
+}
+
+// TestFailureInjection verifies peer crashes, recoveries and link failures triggered by time and by reception events.
+func TestFailureInjection(t *testing.T) {
+	fmt.Println("Test Failure Injection")
+
+	newLine := func() *p2p.P2P {
+		nw, err := p2p.New(lineGraph(t, 4), &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+			SimulatedClock:        true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		return nw
+	}
+
+	flood := func(nw *p2p.P2P) float64 {
+		if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		return nw.Reachability("msg")
+	}
+
+	fmt.Println("- Test crash on reception")
+	nw := newLine()
+	if err := nw.CrashOn("2", p2p.Trigger{ID: "msg", Peer: "1"}); err != nil {
+		t.Fatalf("failed to register trigger: %v", err)
+	}
+	if r := flood(nw); r != 0.5 {
+		t.Fatalf("expected reachability 0.5, got %f", r)
+	}
+
+	// triggers match the message ID, not the content shared by other messages
+	nw = newLine()
+	if err := nw.CrashOn("2", p2p.Trigger{ID: "second", Peer: "1"}); err != nil {
+		t.Fatalf("failed to register trigger: %v", err)
+	}
+	for _, id := range []string{"first", "second"} {
+		if err := nw.PublishMessage("0", p2p.Message{ID: id, Content: "msg", Protocol: p2p.Flooding}); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+	}
+	if nw.Reachability("first") != 1 || nw.Reachability("second") != 0.5 {
+		t.Fatalf("expected only the second message to fire the trigger, got %f and %f", nw.Reachability("first"), nw.Reachability("second"))
+	}
+
+	fmt.Println("- Test scheduled crash and recovery")
+	nw = newLine()
+	nw.ScheduleCrash("3", 0)
+	nw.ScheduleRecover("3", 40)
+	if r := flood(nw); r != 1 {
+		t.Fatalf("expected reachability 1 after recovery, got %f", r)
+	}
+
+	fmt.Println("- Test link failure")
+	nw = newLine()
+	if err := nw.FailLink("1", "2"); err != nil {
+		t.Fatalf("failed to fail link: %v", err)
+	}
+	if err := nw.FailLink("0", "2"); err == nil {
+		t.Fatalf("expected error failing a non-existent link, got nil")
+	}
+	if r := flood(nw); r != 0.5 {
+		t.Fatalf("expected reachability 0.5, got %f", r)
+	}
+
+	fmt.Println("- Test churn")
+	g, err := standard.ErdosRenyiGraph(5, false, nil, 200, 0.03)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	churned := func() []time.Time {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 30 },
+			SimulatedClock:        true,
+			Seed:                  1,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		if err := nw.StartChurn(p2p.ChurnModel{MeanSession: 100, MeanDowntime: 100}); err == nil {
+			t.Fatalf("expected error for churn without a duration")
+		}
+		if err := nw.StartChurn(p2p.ChurnModel{MeanSession: 100, MeanDowntime: 100, Duration: 1000}); err != nil {
+			t.Fatalf("failed to start churn: %v", err)
+		}
+		if err := nw.Publish(nw.PeerIDs()[0], "msg", p2p.Flooding, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		if r := nw.Reachability("msg"); r >= 1 {
+			t.Fatalf("expected churn to reduce reachability, got %f", r)
+		}
+
+		times := nw.FirstMessageReceptionTimes("msg")
+		slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+		return times
+	}
+
+	if !slices.Equal(churned(), churned()) {
+		t.Fatalf("expected identical reception times under seeded churn")
+	}
+}
+
+// TestDynamicTopology verifies adding and removing peers and links, and exporting the topology as a graph.
+func TestDynamicTopology(t *testing.T) {
+	fmt.Println("Test Dynamic Topology")
+
+	nw, err := p2p.New(lineGraph(t, 4), &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		SimulatedClock:        true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.AddPeer("4"); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if err := nw.AddPeer("4"); err == nil {
+		t.Fatalf("expected error adding duplicate peer, got nil")
+	}
+	if err := nw.Connect("3", "4"); err != nil {
+		t.Fatalf("failed to connect peers: %v", err)
+	}
+	if err := nw.Connect("4", "3"); err == nil {
+		t.Fatalf("expected error connecting already connected peers, got nil")
+	}
+	if err := nw.Connect("0", "4"); err != nil {
+		t.Fatalf("failed to connect peers: %v", err)
+	}
+
+	g, err := nw.Graph()
+	if err != nil {
+		t.Fatalf("failed to export graph: %v", err)
+	}
+	if g.Size() != 5 || !g.HasEdge("4", "0") || !g.HasEdge("3", "4") || g.HasEdge("0", "2") {
+		t.Fatalf("unexpected exported topology:\n%s", g.String())
+	}
+
+	if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+
+	// cut the original line so that the message can only travel through the new peer
+	if err := nw.Disconnect("0", "1"); err != nil {
+		t.Fatalf("failed to disconnect peers: %v", err)
+	}
+	if err := nw.RemovePeer("2"); err != nil {
+		t.Fatalf("failed to remove peer: %v", err)
+	}
+
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	if r := nw.Reachability("msg"); r != 0.75 {
+		t.Fatalf("expected reachability 0.75, got %f", r)
+	}
+
+	neighbors, err := nw.Neighbors("3")
+	if err != nil {
+		t.Fatalf("failed to get neighbors: %v", err)
+	}
+	if !slices.Equal(neighbors, []p2p.PeerID{"4"}) {
+		t.Fatalf("expected neighbors [4], got %v", neighbors)
+	}
+}
+
+// TestTraceExport verifies that send and receive events are streamed and exported as JSON Lines and CSV.
+func TestTraceExport(t *testing.T) {
+	fmt.Println("Test Trace Export")
+
+	stream := &bytes.Buffer{}
+	writer := p2p.NewCSVTraceWriter(stream)
+
+	nw, err := p2p.New(lineGraph(t, 4), &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		SimulatedClock:        true,
+		TraceWriter:           writer,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("failed to flush trace: %v", err)
+	}
+
+	trace := nw.Trace()
+	if len(trace) != 7 {
+		t.Fatalf("expected 7 trace events, got %d", len(trace))
+	}
+
+	last := trace[len(trace)-1]
+	if last.Peer != "3" || last.Type != p2p.EventRecv || last.Hop != 3 || last.Elapsed != 45 || !last.First {
+		t.Fatalf("unexpected last trace event: %+v", last)
+	}
+
+	rows, err := csv.NewReader(stream).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read streamed trace: %v", err)
+	}
+	if len(rows) != len(trace)+1 || rows[0][0] != "peer" {
+		t.Fatalf("expected header and %d rows in streamed trace, got %d rows", len(trace), len(rows))
+	}
+
+	jsonl := &bytes.Buffer{}
+	if err := trace.WriteJSONL(jsonl); err != nil {
+		t.Fatalf("failed to write trace: %v", err)
+	}
+
+	decoder := json.NewDecoder(jsonl)
+	decoded := make(p2p.Trace, 0)
+	for decoder.More() {
+		var ev p2p.TraceEvent
+		if err := decoder.Decode(&ev); err != nil {
+			t.Fatalf("failed to decode trace event: %v", err)
+		}
+		decoded = append(decoded, ev)
+	}
+
+	if len(decoded) != len(trace) || decoded[3].To != trace[3].To || !decoded[3].Time.Equal(trace[3].Time) {
+		t.Fatalf("decoded trace does not match exported trace")
+	}
+
+	empty := &bytes.Buffer{}
+	if err := (p2p.Trace{}).WriteCSV(empty); err != nil {
+		t.Fatalf("failed to write empty trace: %v", err)
+	}
+	if rows, _ := csv.NewReader(empty).ReadAll(); len(rows) != 1 || rows[0][0] != "peer" {
+		t.Fatalf("expected a header row for an empty trace, got %v", rows)
+	}
+
+	fmt.Println("- Test trace writer errors")
+
+	if nw.TraceError() != nil {
+		t.Fatalf("expected no trace error, got %v", nw.TraceError())
+	}
+
+	failing, err := p2p.New(lineGraph(t, 4), &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		SimulatedClock:        true,
+		TraceWriter:           &failingTraceWriter{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	failing.Run(context.Background())
+
+	if err := failing.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := failing.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if err := failing.TraceError(); err == nil || err.Error() != "write 1 failed" {
+		t.Fatalf("expected the first trace writer error, got %v", err)
+	}
+	if len(failing.Trace()) != 7 {
+		t.Fatalf("expected the trace to be recorded despite writer errors, got %d events", len(failing.Trace()))
+	}
+}
+
+// failingTraceWriter is a trace writer whose every write fails.
+type failingTraceWriter struct {
+	writes int
+}
+
+// WriteEvent counts the write and returns an error naming it.
+func (w *failingTraceWriter) WriteEvent(ev p2p.TraceEvent) error {
+	w.writes++
+
+	return fmt.Errorf("write %d failed", w.writes)
+}
+
+// TestStats verifies the propagation statistics of a flooded message.
+func TestStats(t *testing.T) {
+	fmt.Println("Test Stats")
+
+	nw, err := p2p.New(lineGraph(t, 4), &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		SimulatedClock:        true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if _, err := nw.Stats("msg"); err == nil {
+		t.Fatalf("expected error for unpublished message, got nil")
+	}
+
+	if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	stats, err := nw.Stats("msg")
+	if err != nil {
+		t.Fatalf("failed to compute stats: %v", err)
+	}
+
+	if stats.Coverage != 1 || stats.Reached != 4 || stats.Sent != 3 || stats.Duplicates != 0 || stats.Redundancy != 0 {
+		t.Fatalf("unexpected coverage or message counts: %+v", stats)
+	}
+	if stats.LatencyP50 != 30 || stats.LatencyP90 != 45 || stats.LatencyMax != 45 {
+		t.Fatalf("unexpected latency percentiles: %+v", stats)
+	}
+	if !maps.Equal(stats.Hops, map[int]int{0: 1, 1: 1, 2: 1, 3: 1}) {
+		t.Fatalf("unexpected hop distribution: %v", stats.Hops)
+	}
+
+	if d, ok := stats.TimeToCoverage(0.5); !ok || d != 15 {
+		t.Fatalf("expected 50%% coverage at 15ms, got %f (%t)", d, ok)
+	}
+	if d, ok := stats.TimeToCoverage(1); !ok || d != 45 {
+		t.Fatalf("expected full coverage at 45ms, got %f (%t)", d, ok)
+	}
+}
+
+// TestDisseminationTree verifies that the first-reception parents form a tree weighted by per-hop latency.
+func TestDisseminationTree(t *testing.T) {
+	fmt.Println("Test Dissemination Tree")
+
+	g, err := standard.ErdosRenyiGraph(9, false, nil, 200, 0.05)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	nw, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		SimulatedClock:        true,
+		Seed:                  9,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.Publish("0", "msg", p2p.Gossip, map[string]any{"gossip_factor": 0.5}, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	tree, err := nw.DisseminationTree("msg", true)
+	if err != nil {
+		t.Fatalf("failed to build dissemination tree: %v", err)
+	}
+
+	stats, err := nw.Stats("msg")
+	if err != nil {
+		t.Fatalf("failed to compute stats: %v", err)
+	}
+
+	if tree.Size() != stats.Reached {
+		t.Fatalf("expected %d nodes in tree, got %d", stats.Reached, tree.Size())
+	}
+
+	edges := 0
+	for _, id := range tree.Nodes() {
+		node, _ := tree.Node(id)
+		edges += node.Degree()
+
+		for _, child := range node.Neighbors() {
+			if w, _ := tree.EdgeWeight(id, child); w != 15 {
+				t.Fatalf("expected per-hop latency 15 on edge %s -> %s, got %f", id, child, w)
+			}
+		}
+	}
+
+	if edges != tree.Size()-1 {
+		t.Fatalf("expected %d edges in tree, got %d", tree.Size()-1, edges)
+	}
+
+	// the critical path from the publisher to the last reached peer spans the maximum latency
+	last := p2p.Reception{}
+	for _, rec := range nw.FirstMessageReceptions("msg") {
+		if rec.Timestamp.After(last.Timestamp) {
+			last = rec
+		}
+	}
+
+	a := analyzer.New(tree, 1, analyzer.DefaultConfig())
+	paths, err := a.ShortestPaths("0", graph.NodeID(last.PeerID))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected a single path to %s, got %d (%v)", last.PeerID, len(paths), err)
+	}
+	if d := float64(paths[0].TotalDistance()); d != stats.LatencyMax {
+		t.Fatalf("expected critical path latency %f, got %f", stats.LatencyMax, d)
+	}
+}
+
+// TestBandwidth verifies serialization delay on the upload link and queueing on the download link.
+func TestBandwidth(t *testing.T) {
+	fmt.Println("Test Bandwidth")
+
+	star := graph.New(false, false)
+	for _, id := range []graph.NodeID{"c", "a", "b", "d"} {
+		star.AddNode(id)
+	}
+	for _, id := range []graph.NodeID{"a", "b", "d"} {
+		star.AddEdge("c", id, nil)
+	}
+
+	fmt.Println("- Test upload serialization")
+
+	// 10 KB at 80 Mbps takes 1 ms to transmit
+	nw, err := p2p.New(star, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 0 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		UploadBandwidthFunc:   func(id p2p.PeerID) float64 { return 80 },
+		SimulatedClock:        true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	if err := nw.PublishMessage("c", p2p.Message{Content: "block", Size: 10000, Protocol: p2p.Flooding}); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	stats, err := nw.Stats("block")
+	if err != nil {
+		t.Fatalf("failed to compute stats: %v", err)
+	}
+	if !slices.Equal(stats.Arrivals, []float64{0, 6, 7, 8}) {
+		t.Fatalf("expected arrivals [0 6 7 8], got %v", stats.Arrivals)
+	}
+
+	fmt.Println("- Test download queueing")
+
+	nw, err = p2p.New(star, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 0 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		DownloadBandwidthFunc: func(id p2p.PeerID) float64 { return 80 },
+		SimulatedClock:        true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	nw.PublishMessage("a", p2p.Message{Content: "first", Size: 10000, Protocol: p2p.Flooding})
+	nw.PublishMessage("b", p2p.Message{Content: "second", Size: 10000, Protocol: p2p.Flooding})
+
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	for content, expected := range map[string]time.Duration{"first": 6 * time.Millisecond, "second": 7 * time.Millisecond} {
+		info, err := nw.PeerLog("c", content)
+		if err != nil {
+			t.Fatalf("failed to get peer log: %v", err)
+		}
+
+		recv := info[content][0]
+		if got := recv.Time.Sub(time.Unix(0, 0)); recv.Type != p2p.EventRecv || got != expected {
+			t.Fatalf("expected %s to be received at %v, got %v", content, expected, got)
+		}
+	}
+}
+
+// slowTraceWriter slows down the handling of messages received by one peer, so that its message queue fills up.
+type slowTraceWriter struct {
+	peer  p2p.PeerID
+	delay time.Duration
+}
+
+func (w *slowTraceWriter) WriteEvent(ev p2p.TraceEvent) error {
+	if ev.Peer == w.peer && ev.Type == p2p.EventRecv {
+		time.Sleep(w.delay)
+	}
+
+	return nil
+}
+
+// TestOverflowPolicy verifies that full message queues drop messages according to the overflow policy
+// instead of blocking forever, and that delivering to stopped peers does not panic.
+func TestOverflowPolicy(t *testing.T) {
+	fmt.Println("Test Overflow Policy")
+
+	star := graph.New(false, false)
+	star.AddNode("hub")
+	for i := 0; i < 30; i++ {
+		leaf := graph.NodeID(fmt.Sprintf("leaf-%d", i))
+		star.AddNode(leaf)
+		star.AddEdge("hub", leaf, nil)
+	}
+
+	run := func(policy p2p.OverflowPolicy) *p2p.P2P {
+		nw, err := p2p.New(star, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 0 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 1 },
+			QueueCapacity:         2,
+			OverflowPolicy:        policy,
+			TraceWriter:           &slowTraceWriter{peer: "hub", delay: 2 * time.Millisecond},
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		for i := 0; i < 30; i++ {
+			leaf := p2p.PeerID(fmt.Sprintf("leaf-%d", i))
+			if err := nw.Publish(leaf, string(leaf), p2p.Flooding, nil, nil); err != nil {
+				t.Fatalf("failed to publish message: %v", err)
+			}
+		}
+
+		time.Sleep(300 * time.Millisecond)
+
+		return nw
+	}
+
+	for _, policy := range []p2p.OverflowPolicy{p2p.OverflowDropTail, p2p.OverflowDropOldest} {
+		fmt.Printf("- Test %s\n", policy)
+
+		nw := run(policy)
+		dropped, err := nw.DroppedMessages("hub")
+		if err != nil {
+			t.Fatalf("failed to get dropped messages: %v", err)
+		}
+		if dropped == 0 || nw.DropCounts()["hub"] != dropped {
+			t.Fatalf("expected the hub to drop messages, got %d", dropped)
+		}
+
+		nw.Free()
+	}
+
+	fmt.Println("- Test block")
+
+	nw := run(p2p.OverflowBlock)
+	if dropped, _ := nw.DroppedMessages("hub"); dropped != 0 {
+		t.Fatalf("expected no dropped messages when blocking, got %d", dropped)
+	}
+
+	// stopping the network while messages are in flight must not panic
+	nw.Free()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := p2p.New(star, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 0 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 1 },
+		OverflowPolicy:        "unknown",
+	}); err == nil {
+		t.Fatalf("expected error for unknown overflow policy, got nil")
+	}
+}
+
+// TestAntiEntropy verifies that anti-entropy rounds complete the dissemination of a stalled push protocol,
+// and disseminate messages on their own when combined with the Pull protocol.
+func TestAntiEntropy(t *testing.T) {
+	fmt.Println("Test Anti-Entropy")
+
+	g, err := standard.WattsStrogatzGraph(1, false, nil, 100, 4, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	run := func(protocol p2p.ProtocolFunc, ae *p2p.AntiEntropyConfig) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+			SimulatedClock:        true,
+			AntiEntropy:           ae,
+			Seed:                  7,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		if err := nw.Publish("0", "msg", protocol, map[string]any{"gossip_node": 1}, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+
+		return nw
+	}
+
+	fmt.Println("- Test push only")
+
+	push := run(p2p.Gossip, nil)
+	if _, err := push.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if r := push.Reachability("msg"); r == 1 {
+		t.Fatalf("expected push-only gossip with one target to stall, got reachability %f", r)
+	}
+
+	fmt.Println("- Test push-pull")
+
+	pushPull := run(p2p.Gossip, &p2p.AntiEntropyConfig{Interval: 50})
+	elapsed, err := pushPull.RunFor(10 * time.Second)
+	if err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if elapsed != 10*time.Second {
+		t.Fatalf("expected simulation to run for 10s, got %v", elapsed)
+	}
+	if r := pushPull.Reachability("msg"); r != 1 {
+		t.Fatalf("expected push-pull reachability 1, got %f", r)
+	}
+
+	fmt.Println("- Test pull only")
+
+	pull := run(p2p.Pull, &p2p.AntiEntropyConfig{Interval: 50, Fanout: 2, Mode: p2p.AntiEntropyPull})
+	if _, err := pull.RunFor(50 * time.Millisecond); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	early := pull.Reachability("msg")
+
+	if _, err := pull.RunFor(10 * time.Second); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if r := pull.Reachability("msg"); r != 1 || early >= r {
+		t.Fatalf("expected pull reachability to grow to 1, got %f then %f", early, r)
+	}
+
+	digests := 0
+	for _, ev := range pull.Trace() {
+		if ev.Type == p2p.EventSend && ev.Kind == p2p.KindDigest {
+			digests++
+		}
+		if ev.Kind == p2p.KindDigestReply {
+			t.Fatalf("expected no digest replies in pull mode")
+		}
+	}
+	if digests == 0 {
+		t.Fatalf("expected digests to be sent")
+	}
+
+	fmt.Println("- Test round limit")
+
+	limited := run(p2p.Pull, &p2p.AntiEntropyConfig{Interval: 50, Rounds: 3, Mode: p2p.AntiEntropyPush})
+	elapsed, err = limited.RunUntilQuiescent()
+	if err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if elapsed >= 200*time.Millisecond {
+		t.Fatalf("expected rounds to end before 200ms, got %v", elapsed)
+	}
+
+	stats, err := limited.Stats("msg")
+	if err != nil {
+		t.Fatalf("failed to compute stats: %v", err)
+	}
+	if stats.Reached <= 1 || stats.Sent != stats.Reached-1 {
+		t.Fatalf("expected pushed messages to reach new peers only, got %+v", stats)
+	}
+}
+
+// TestAnnounce verifies that two-phase propagation sends each message once per peer, at the cost of
+// two extra link traversals per hop, and that requests are retried with other announcing peers.
+func TestAnnounce(t *testing.T) {
+	fmt.Println("Test Announce")
+
+	newNetwork := func(g *graph.Graph, announce *p2p.AnnounceConfig) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 10 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+			SimulatedClock:        true,
+			Announce:              announce,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		return nw
+	}
+
+	fmt.Println("- Test latency on a line")
+
+	line := newNetwork(lineGraph(t, 4), &p2p.AnnounceConfig{})
+	if err := line.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := line.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	stats, err := line.Stats("msg")
+	if err != nil {
+		t.Fatalf("failed to compute stats: %v", err)
+	}
+	if stats.Reached != 4 || stats.LatencyMax != 75 || stats.Sent != 3 {
+		t.Fatalf("expected 4 peers reached within 75ms with 3 sends, got %+v", stats)
+	}
+
+	fmt.Println("- Test duplicates")
+
+	g, err := standard.WattsStrogatzGraph(1, false, nil, 50, 6, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	for _, announce := range []*p2p.AnnounceConfig{nil, {AnnounceSize: 32, RequestSize: 32}} {
+		nw := newNetwork(g, announce)
+		if err := nw.PublishMessage("0", p2p.Message{Content: "msg", Size: 1000, Protocol: p2p.Flooding}); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		stats, err := nw.Stats("msg")
+		if err != nil {
+			t.Fatalf("failed to compute stats: %v", err)
+		}
+		if stats.Coverage != 1 {
+			t.Fatalf("expected full coverage, got %f", stats.Coverage)
+		}
+
+		if announce == nil && stats.Duplicates == 0 {
+			t.Fatalf("expected flooding to send duplicates")
+		}
+		if announce != nil && (stats.Duplicates != 0 || stats.Sent != stats.Reached-1) {
+			t.Fatalf("expected announcing to send each message once per peer, got %+v", stats)
+		}
+	}
+
+	fmt.Println("- Test request timeout")
+
+	diamond := graph.New(false, false)
+	for _, id := range []graph.NodeID{"0", "1", "2", "3"} {
+		diamond.AddNode(id)
+	}
+	for _, e := range [][2]graph.NodeID{{"0", "1"}, {"0", "2"}, {"1", "3"}, {"2", "3"}} {
+		diamond.AddEdge(e[0], e[1], nil)
+	}
+
+	for _, timeout := range []float64{0, 20} {
+		nw := newNetwork(diamond, &p2p.AnnounceConfig{RequestTimeout: timeout})
+
+		if err := nw.ScheduleCrash("1", 42); err != nil {
+			t.Fatalf("failed to schedule crash: %v", err)
+		}
+		if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		receptions := nw.FirstMessageReceptions("msg")
+		idx := slices.IndexFunc(receptions, func(r p2p.Reception) bool { return r.PeerID == "3" })
+
+		if timeout == 0 && idx != -1 {
+			t.Fatalf("expected peer 3 to wait forever for the crashed peer")
+		}
+		if timeout > 0 {
+			if idx == -1 {
+				t.Fatalf("expected peer 3 to request the message again")
+			}
+			if r := receptions[idx]; r.From != "2" || r.Timestamp.Sub(time.Unix(0, 0)) != 70*time.Millisecond {
+				t.Fatalf("expected peer 3 to receive from 2 at 70ms, got %+v", r)
+			}
+		}
+	}
+}
+
+// TestGossipSub verifies that GossipSub meshes are built within their degree bounds, deliver topic messages
+// to every subscriber with fewer transmissions than flooding, and are left by unsubscribing peers.
+func TestGossipSub(t *testing.T) {
+	fmt.Println("Test GossipSub")
+
+	g, err := standard.WattsStrogatzGraph(1, false, nil, 100, 10, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	cfg := &p2p.GossipSubConfig{D: 4, DLow: 3, DHigh: 6, DLazy: 3, HeartbeatInterval: 100}
+
+	nw, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+		SimulatedClock:        true,
+		GossipSub:             cfg,
+		Seed:                  3,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	for i, id := range nw.PeerIDs() {
+		if i%10 == 0 {
+			continue
+		}
+		if err := nw.Subscribe(id, "blocks"); err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+	}
+
+	subscribers := nw.Subscribers("blocks")
+	if len(subscribers) != 90 {
+		t.Fatalf("expected 90 subscribers, got %d", len(subscribers))
+	}
+
+	nw.Run(context.Background())
+
+	if _, err := nw.RunFor(time.Second); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	fmt.Println("- Test mesh degrees")
+
+	for _, id := range subscribers {
+		mesh, err := nw.Mesh(id, "blocks")
+		if err != nil {
+			t.Fatalf("failed to get mesh: %v", err)
+		}
+		if len(mesh) < cfg.DLow || len(mesh) > cfg.DHigh {
+			t.Fatalf("expected mesh of %s to have between %d and %d peers, got %v", id, cfg.DLow, cfg.DHigh, mesh)
+		}
+		for _, member := range mesh {
+			if !slices.Contains(subscribers, member) {
+				t.Fatalf("expected mesh of %s to contain subscribers only, got %s", id, member)
+			}
+		}
+	}
+
+	fmt.Println("- Test delivery")
+
+	for _, publisher := range []p2p.PeerID{subscribers[0], "0"} {
+		content := "block-from-" + string(publisher)
+
+		if err := nw.PublishMessage(publisher, p2p.Message{Content: content, Topic: "blocks"}); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunFor(2 * time.Second); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		received := make([]p2p.PeerID, 0)
+		for _, r := range nw.FirstMessageReceptions(content) {
+			if r.PeerID != publisher {
+				received = append(received, r.PeerID)
+			}
+		}
+		slices.Sort(received)
+
+		expected := slices.DeleteFunc(slices.Clone(subscribers), func(id p2p.PeerID) bool { return id == publisher })
+		if !slices.Equal(received, expected) {
+			t.Fatalf("expected every subscriber to receive %s, got %d receptions", content, len(received))
+		}
+
+		stats, err := nw.Stats(content)
+		if err != nil {
+			t.Fatalf("failed to compute stats: %v", err)
+		}
+		if stats.Sent >= 90*10 {
+			t.Fatalf("expected fewer transmissions than flooding, got %d", stats.Sent)
+		}
+	}
+
+	fmt.Println("- Test unsubscribe")
+
+	leaving := subscribers[1]
+	if err := nw.Unsubscribe(leaving, "blocks"); err != nil {
+		t.Fatalf("failed to unsubscribe: %v", err)
+	}
+	if _, err := nw.RunFor(time.Second); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	for _, id := range nw.Subscribers("blocks") {
+		mesh, _ := nw.Mesh(id, "blocks")
+		if slices.Contains(mesh, leaving) {
+			t.Fatalf("expected %s to have left the mesh of %s", leaving, id)
+		}
+	}
+	if mesh, _ := nw.Mesh(leaving, "blocks"); len(mesh) != 0 {
+		t.Fatalf("expected %s to have no mesh, got %v", leaving, mesh)
+	}
+
+	fmt.Println("- Test heartbeat limit")
+
+	if _, err := nw.RunUntilQuiescent(); err == nil {
+		t.Fatalf("expected error for unlimited heartbeats")
+	}
+
+	limited, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+		SimulatedClock:        true,
+		GossipSub:             &p2p.GossipSubConfig{HeartbeatInterval: 100, Heartbeats: 5},
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	limited.Run(context.Background())
+
+	elapsed, err := limited.RunUntilQuiescent()
+	if err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if elapsed > 600*time.Millisecond {
+		t.Fatalf("expected heartbeats to stop after 5 intervals, ran for %v", elapsed)
+	}
+}
+
+// TestPlumtree verifies that Plumtree converges to a broadcast tree after the first message,
+// so that later messages are received without duplicates, and repairs the tree with GRAFT when a peer crashes.
+func TestPlumtree(t *testing.T) {
+	fmt.Println("Test Plumtree")
+
+	g, err := standard.WattsStrogatzGraph(1, false, nil, 100, 6, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	latency := func(src, dst p2p.PeerID) float64 {
+		var a, b int
+		fmt.Sscanf(string(src), "%d", &a)
+		fmt.Sscanf(string(dst), "%d", &b)
+
+		return float64(5 + (a*7+b*13)%20)
+	}
+
+	flood, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    latency,
+		SimulatedClock:        true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	flood.Run(context.Background())
+	flood.Publish("0", "msg-0", p2p.Flooding, nil, nil)
+	flood.RunUntilQuiescent()
+
+	nw, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    latency,
+		SimulatedClock:        true,
+		Plumtree:              &p2p.PlumtreeConfig{GraftTimeout: 100},
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	nw.Run(context.Background())
+
+	fmt.Println("- Test convergence")
+
+	for i := range 3 {
+		content := fmt.Sprintf("msg-%d", i)
+
+		if err := nw.Publish("0", content, nil, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		stats, err := nw.Stats(content)
+		if err != nil {
+			t.Fatalf("failed to compute stats: %v", err)
+		}
+		if stats.Coverage != 1 {
+			t.Fatalf("expected full coverage of %s, got %f", content, stats.Coverage)
+		}
+
+		if i == 0 && stats.Duplicates == 0 {
+			t.Fatalf("expected duplicates while the tree is built")
+		}
+		if i > 0 && (stats.Duplicates != 0 || stats.Sent != stats.Reached-1) {
+			t.Fatalf("expected %s to follow the tree without duplicates, got %+v", content, stats)
+		}
+	}
+
+	if nw.DuplicateMessageCount("msg-2") >= flood.DuplicateMessageCount("msg-0") {
+		t.Fatalf("expected fewer duplicates than flooding")
+	}
+
+	fmt.Println("- Test repair")
+
+	lazy, err := nw.LazyPeers("0")
+	if err != nil {
+		t.Fatalf("failed to get lazy peers: %v", err)
+	}
+	neighbors, _ := nw.Neighbors("0")
+
+	var child p2p.PeerID
+	for _, id := range neighbors {
+		if !slices.Contains(lazy, id) {
+			child = id
+			break
+		}
+	}
+
+	if err := nw.Crash(child); err != nil {
+		t.Fatalf("failed to crash peer: %v", err)
+	}
+	if err := nw.Publish("0", "after-crash", nil, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	stats, err := nw.Stats("after-crash")
+	if err != nil {
+		t.Fatalf("failed to compute stats: %v", err)
+	}
+	if stats.Reached != 99 {
+		t.Fatalf("expected every live peer to be reached after grafting, got %d", stats.Reached)
+	}
+
+	grafts := 0
+	for _, ev := range nw.Trace() {
+		if ev.Type == p2p.EventSend && ev.Kind == p2p.KindGraft {
+			grafts++
+		}
+	}
+	if grafts == 0 {
+		t.Fatalf("expected the tree to be repaired with grafts")
+	}
+}
+
+// TestDandelion verifies that Dandelion++ relays messages along stems before fluffing them to every peer,
+// and that it lowers the precision of the first-spy estimator compared to flooding.
+func TestDandelion(t *testing.T) {
+	fmt.Println("Test Dandelion")
+
+	g, err := standard.WattsStrogatzGraph(1, false, nil, 200, 8, 0.2)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	ids := make([]p2p.PeerID, 0)
+	for _, n := range g.Nodes() {
+		ids = append(ids, p2p.PeerID(n))
+	}
+	slices.Sort(ids)
+
+	spies := make([]p2p.PeerID, 0)
+	honest := make([]p2p.PeerID, 0)
+	for i, id := range ids {
+		if i%10 == 0 {
+			spies = append(spies, id)
+		} else {
+			honest = append(honest, id)
+		}
+	}
+
+	run := func(dandelion *p2p.DandelionConfig) (*p2p.P2P, []string) {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+			SimulatedClock:        true,
+			Dandelion:             dandelion,
+			Seed:                  11,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		msgs := make([]string, 0)
+		for i := range 50 {
+			content := fmt.Sprintf("tx-%d", i)
+			publisher := honest[(i*37)%len(honest)]
+
+			if err := nw.PublishMessage(publisher, p2p.Message{Content: content, Protocol: p2p.Flooding, Stem: true}); err != nil {
+				t.Fatalf("failed to publish message: %v", err)
+			}
+
+			msgs = append(msgs, content)
+		}
+
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		for _, content := range msgs {
+			if r := nw.Reachability(content); r != 1 {
+				t.Fatalf("expected %s to reach every peer, got %f", content, r)
+			}
+		}
+
+		return nw, msgs
+	}
+
+	flood, msgs := run(nil)
+
+	floodResult, err := flood.Deanonymize(msgs, spies)
+	if err != nil {
+		t.Fatalf("failed to deanonymize: %v", err)
+	}
+
+	dandelion, _ := run(&p2p.DandelionConfig{EmbargoTimeout: 200})
+
+	relays, err := dandelion.StemRelays(honest[0])
+	if err != nil || len(relays) != 2 {
+		t.Fatalf("expected 2 stem relays, got %v (%v)", relays, err)
+	}
+
+	// epochs redraw the relays a bounded number of times, so that the network still becomes quiescent
+	run(&p2p.DandelionConfig{EmbargoTimeout: 200, EpochLength: 50, Epochs: 3})
+
+	if err := (&p2p.Config{Dandelion: &p2p.DandelionConfig{EpochLength: 50}}).CheckQuiescence(); err == nil {
+		t.Fatalf("expected error for unlimited epochs")
+	}
+
+	fmt.Println("- Test default coverage")
+
+	// with the default configuration, stems loop back and cross cut vertices, which must not stop the fluff phase
+	for seed := range 10 {
+		sparse, err := standard.ErdosRenyiGraph(seed, false, nil, 60, 0.08)
+		if err != nil {
+			t.Fatalf("failed to generate graph: %v", err)
+		}
+
+		reach := func(dandelion *p2p.DandelionConfig) float64 {
+			nw, err := p2p.New(sparse, &p2p.Config{
+				ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+				NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+				SimulatedClock:        true,
+				Dandelion:             dandelion,
+				Seed:                  uint64(seed),
+			})
+			if err != nil {
+				t.Fatalf("failed to create network: %v", err)
+			}
+
+			nw.Run(context.Background())
+
+			if err := nw.PublishMessage("0", p2p.Message{Content: "tx", Protocol: p2p.Flooding, Stem: true}); err != nil {
+				t.Fatalf("failed to publish message: %v", err)
+			}
+			if _, err := nw.RunUntilQuiescent(); err != nil {
+				t.Fatalf("failed to run simulation: %v", err)
+			}
+
+			return nw.Reachability("tx")
+		}
+
+		if flooding, stem := reach(nil), reach(&p2p.DandelionConfig{}); stem != flooding {
+			t.Fatalf("expected dandelion to reach as many peers as flooding on graph %d, got %f and %f", seed, stem, flooding)
+		}
+	}
+
+	dandelionResult, err := dandelion.Deanonymize(msgs, spies)
+	if err != nil {
+		t.Fatalf("failed to deanonymize: %v", err)
+	}
+
+	fmt.Printf("- Precision: flooding %.2f, dandelion %.2f\n", floodResult.Precision, dandelionResult.Precision)
+
+	if floodResult.Messages != 50 || floodResult.Observed != 50 {
+		t.Fatalf("expected every message to be observed, got %+v", floodResult)
+	}
+	if dandelionResult.Precision >= floodResult.Precision {
+		t.Fatalf("expected dandelion to lower the first-spy precision from %f, got %f", floodResult.Precision, dandelionResult.Precision)
+	}
+
+	estimate, observed, err := dandelion.FirstSpy(msgs[0], spies)
+	if err != nil || !observed || estimate != dandelionResult.Estimates[msgs[0]] {
+		t.Fatalf("expected first-spy estimate to match the analysis, got %s", estimate)
+	}
+}
+
+// TestBehaviors verifies that misbehaving peers drop, delay and tamper with the messages they send,
+// and that the behavior report measures their impact on honest peers.
+func TestBehaviors(t *testing.T) {
+	fmt.Println("Test Behaviors")
+
+	g, err := standard.WattsStrogatzGraph(1, false, nil, 200, 6, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	ids := make([]p2p.PeerID, 0)
+	for _, n := range g.Nodes() {
+		ids = append(ids, p2p.PeerID(n))
+	}
+
+	run := func(behaviors func(id p2p.PeerID) p2p.Behavior) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+			SimulatedClock:        true,
+			BehaviorFunc:          behaviors,
+			Seed:                  13,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		publisher := slices.DeleteFunc(slices.Clone(ids), func(id p2p.PeerID) bool {
+			return slices.Contains(nw.Misbehaving(), id)
+		})[0]
+
+		if err := nw.Publish(publisher, "msg", p2p.Flooding, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		return nw
+	}
+
+	report := func(nw *p2p.P2P) *p2p.BehaviorReport {
+		r, err := nw.BehaviorReport("msg")
+		if err != nil {
+			t.Fatalf("failed to compute report: %v", err)
+		}
+
+		return r
+	}
+
+	baseline := report(run(nil))
+	if baseline.Coverage != 1 || len(baseline.Misbehaving) != 0 {
+		t.Fatalf("expected full coverage without misbehaving peers, got %+v", baseline)
+	}
+
+	fmt.Println("- Test silent peers")
+
+	silent := run(p2p.RandomBehaviors(ids, 0.4, p2p.Silent, 1))
+	silentReport := report(silent)
+
+	if len(silentReport.Misbehaving) != 80 || silentReport.Honest != 120 {
+		t.Fatalf("expected 80 misbehaving peers, got %+v", silentReport)
+	}
+	if silentReport.Coverage >= 1 || silentReport.LatencyP50 <= baseline.LatencyP50 {
+		t.Fatalf("expected silent peers to lower coverage and raise latency, got %+v", silentReport)
+	}
+
+	for _, id := range silentReport.Misbehaving {
+		info, _ := silent.MessageInfo(id, "msg")
+		if len(info["sent"].([]p2p.PeerID)) != 0 {
+			t.Fatalf("expected silent peer %s to send nothing", id)
+		}
+	}
+
+	fmt.Println("- Test delaying peers")
+
+	delayed := report(run(p2p.RandomBehaviors(ids, 0.4, p2p.Delaying(100), 1)))
+	if delayed.Coverage != 1 || delayed.LatencyP50 <= baseline.LatencyP50 {
+		t.Fatalf("expected delaying peers to raise latency only, got %+v", delayed)
+	}
+
+	fmt.Println("- Test selective peers")
+
+	dropAll := report(run(p2p.RandomBehaviors(ids, 0.4, p2p.Selective(0), 1)))
+	if dropAll.Coverage != silentReport.Coverage {
+		t.Fatalf("expected peers forwarding with probability 0 to act like silent peers")
+	}
+
+	fmt.Println("- Test equivocating peers")
+
+	equivocating := run(p2p.RandomBehaviors(ids, 0.4, p2p.Equivocating, 1))
+	forged := 0
+	for _, ev := range equivocating.Trace() {
+		if ev.Type == p2p.EventRecv && ev.Content != "msg" {
+			forged++
+		}
+	}
+	if forged == 0 {
+		t.Fatalf("expected honest peers to receive forged messages")
+	}
+
+	fmt.Println("- Test fractions out of range")
+
+	for fraction, expected := range map[float64]int{-0.5: 0, 1.5: len(ids)} {
+		behaviors := p2p.RandomBehaviors(ids, fraction, p2p.Silent, 1)
+
+		assigned := 0
+		for _, id := range ids {
+			if behaviors(id) != nil {
+				assigned++
+			}
+		}
+		if assigned != expected {
+			t.Fatalf("expected fraction %v to be clamped to %d peers, got %d", fraction, expected, assigned)
+		}
+	}
+}
+
+// TestAttacks verifies the eclipse and Sybil scenario builders on networks, and the isolation of victims.
+func TestAttacks(t *testing.T) {
+	fmt.Println("Test Attacks")
+
+	fmt.Println("- Test eclipsed peers")
+
+	base, err := standard.WattsStrogatzGraph(1, false, nil, 100, 6, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	run := func(behavior p2p.Behavior) (*p2p.P2P, []p2p.PeerID) {
+		nw, err := p2p.New(base, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+			SimulatedClock:        true,
+			BehaviorFunc: func(id p2p.PeerID) p2p.Behavior {
+				if strings.HasPrefix(string(id), "sybil-") {
+					return behavior
+				}
+
+				return nil
+			},
+			Seed: 3,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		sybils, err := nw.AddSybils(standard.SybilConfig{Count: 4, Links: 3, Attachment: standard.AttachPreferential})
+		if err != nil {
+			t.Fatalf("failed to add Sybil peers: %v", err)
+		}
+		if err := nw.Eclipse("10", sybils); err != nil {
+			t.Fatalf("failed to eclipse peer: %v", err)
+		}
+
+		if neighbors, _ := nw.Neighbors("10"); !slices.Equal(neighbors, sybils) {
+			t.Fatalf("expected only Sybil neighbors, got %v", neighbors)
+		}
+
+		nw.Run(context.Background())
+
+		if err := nw.Publish("0", "msg", p2p.Flooding, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		return nw, sybils
+	}
+
+	silent, attackers := run(p2p.Silent)
+	if len(attackers) != 4 || len(silent.PeerIDs()) != 104 {
+		t.Fatalf("expected 4 Sybil peers, got %v", attackers)
+	}
+
+	isolated, err := silent.Isolation("msg", []p2p.PeerID{"10"}, attackers)
+	if err != nil {
+		t.Fatalf("failed to compute isolation: %v", err)
+	}
+	if isolated.Reached != 0 || isolated.Coverage != 0 {
+		t.Fatalf("expected the victim to be isolated by silent Sybil peers, got %+v", isolated)
+	}
+
+	honest, attackers := run(nil)
+	captured, err := honest.Isolation("msg", []p2p.PeerID{"10"}, attackers)
+	if err != nil {
+		t.Fatalf("failed to compute isolation: %v", err)
+	}
+	if captured.Coverage != 1 || captured.CaptureRate != 1 || captured.VictimLatency <= 0 {
+		t.Fatalf("expected the victim to receive the message from a Sybil peer, got %+v", captured)
+	}
+
+	if _, err := honest.Isolation("missing", nil, nil); err == nil {
+		t.Fatalf("expected error for unpublished message")
+	}
+
+	fmt.Println("- Test repeated Sybil peers")
+
+	more, err := honest.AddSybils(standard.SybilConfig{Count: 2, Links: 3})
+	if err != nil {
+		t.Fatalf("failed to add Sybil peers again: %v", err)
+	}
+	if !slices.Equal(more, []p2p.PeerID{"sybil-4", "sybil-5"}) || len(honest.PeerIDs()) != 106 {
+		t.Fatalf("expected new Sybil peers with unused IDs, got %v", more)
+	}
+}
+
+// TestWorkload verifies that a Poisson workload publishes concurrent messages with their own IDs from the allowed publishers,
+// and that their statistics are aggregated.
+func TestWorkload(t *testing.T) {
+	fmt.Println("Test Workload")
+
+	g, err := standard.BarabasiAlbertGraph(1, false, nil, 100, 3)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	run := func() (*p2p.P2P, []string) {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+			SimulatedClock:        true,
+			Seed:                  4,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		ids, err := nw.StartWorkload(p2p.WorkloadConfig{
+			Rate:       200,
+			Count:      30,
+			Publishers: []p2p.PeerID{"1", "2", "3"},
+			WeightFunc: func(id p2p.PeerID) float64 {
+				if id == "3" {
+					return 0
+				}
+
+				return 1
+			},
+			Template: p2p.Message{Content: "tx", Protocol: p2p.Flooding},
+		})
+		if err != nil {
+			t.Fatalf("failed to start workload: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		return nw, ids
+	}
+
+	nw, ids := run()
+
+	if !slices.Equal(nw.Messages(), ids) || ids[0] != "msg-0" {
+		t.Fatalf("expected messages %v to be published in order, got %v", ids, nw.Messages())
+	}
+
+	fmt.Println("- Test message IDs")
+
+	concurrent := false
+	var previous *p2p.MessageStats
+
+	for _, id := range ids {
+		stats, err := nw.Stats(id)
+		if err != nil {
+			t.Fatalf("failed to compute stats: %v", err)
+		}
+
+		if stats.ID != id || stats.Coverage != 1 {
+			t.Fatalf("expected message %s to reach every peer, got %+v", id, stats)
+		}
+		if stats.Publisher != "1" && stats.Publisher != "2" {
+			t.Fatalf("expected message %s to be published by an allowed publisher, got %s", id, stats.Publisher)
+		}
+
+		if previous != nil && stats.PublishedAt.Before(previous.PublishedAt.Add(time.Duration(previous.LatencyMax*float64(time.Millisecond)))) {
+			concurrent = true
+		}
+		previous = stats
+	}
+
+	if !concurrent {
+		t.Fatalf("expected messages to propagate concurrently")
+	}
+
+	for _, ev := range nw.Trace() {
+		if ev.Kind == p2p.KindPayload && (ev.Content != "tx" || !slices.Contains(ids, ev.ID)) {
+			t.Fatalf("expected every event to carry a workload ID and the shared content, got %+v", ev)
+		}
+	}
+
+	fmt.Println("- Test aggregate stats")
+
+	agg, err := nw.AggregateStats()
+	if err != nil {
+		t.Fatalf("failed to aggregate stats: %v", err)
+	}
+
+	if agg.Messages != 30 || agg.FullCoverage != 30 || agg.MeanCoverage != 1 || agg.MinCoverage != 1 {
+		t.Fatalf("unexpected aggregate coverage: %+v", agg)
+	}
+	if agg.LatencyP50 <= 0 || agg.LatencyP50 > agg.LatencyP90 || agg.LatencyP90 > agg.LatencyMax || agg.Sent < 30*99 {
+		t.Fatalf("unexpected aggregate latencies or message counts: %+v", agg)
+	}
+
+	again, _ := run()
+	if againAgg, _ := again.AggregateStats(); *againAgg != *agg {
+		t.Fatalf("expected identical workloads with the same seed")
+	}
+
+	// the publisher of a message may have been removed, leaving only the arrivals of other peers
+	removed := p2p.Aggregate([]*p2p.MessageStats{{
+		Publisher:  "0",
+		Peers:      2,
+		Reached:    2,
+		Coverage:   1,
+		Arrivals:   []float64{5, 7},
+		ArrivalsOf: map[p2p.PeerID]float64{"1": 5, "2": 7},
+	}})
+	if removed.LatencyMean != 6 || removed.LatencyP50 != 5 || removed.LatencyMax != 7 {
+		t.Fatalf("expected every arrival to count without the publisher, got %+v", removed)
+	}
+
+	if _, err := nw.StartWorkload(p2p.WorkloadConfig{Rate: 0, Count: 1}); err == nil {
+		t.Fatalf("expected error for non-positive rate")
+	}
+
+	fmt.Println("- Test workload errors")
+
+	if nw.WorkloadError() != nil {
+		t.Fatalf("expected no workload error, got %v", nw.WorkloadError())
+	}
+
+	if _, err := nw.StartWorkload(p2p.WorkloadConfig{
+		Rate:     200,
+		Count:    2,
+		Prefix:   "invalid-",
+		Template: p2p.Message{Protocol: p2p.Gossip, StaticParams: map[string]any{"gossip_factor": 2.0}},
+	}); err != nil {
+		t.Fatalf("failed to start workload: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if nw.WorkloadError() == nil {
+		t.Fatalf("expected the invalid workload messages to be reported")
+	}
+
+	fmt.Println("- Test repeated workloads")
+
+	more, err := nw.StartWorkload(p2p.WorkloadConfig{Rate: 200, Count: 3, Template: p2p.Message{Protocol: p2p.Flooding}})
+	if err != nil {
+		t.Fatalf("failed to start workload: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if !slices.Equal(more, []string{"msg-32", "msg-33", "msg-34"}) || len(nw.Messages()) != 33 {
+		t.Fatalf("expected the messages of a second workload to get new IDs, got %v and %d messages", more, len(nw.Messages()))
+	}
+
+	if err := nw.Publish("0", "msg-0", p2p.Flooding, nil, nil); err == nil {
+		t.Fatalf("expected error for an already published message ID")
+	}
+}
+
+// TestTransport verifies that the built-in protocols run unchanged over the loopback socket transports,
+// with latencies at least as large as the injected ones.
+func TestTransport(t *testing.T) {
+	fmt.Println("Test Transport")
+
+	g, err := standard.BarabasiAlbertGraph(1, false, nil, 30, 2)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	run := func(transport p2p.Transport, protocol string, params map[string]any) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+			Transport:             transport,
+			Seed:                  2,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		nw.Run(ctx)
+
+		if err := nw.PublishMessage("0", p2p.Message{Content: "msg", ProtocolName: protocol, StaticParams: params}); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+
+		nw.ExpireSimulation(cancel, "msg", 200*time.Millisecond, 10*time.Second, 10*time.Millisecond)
+
+		return nw
+	}
+
+	for _, transport := range []p2p.Transport{p2p.TransportMemory, p2p.TransportTCP, p2p.TransportUDP} {
+		fmt.Printf("- Test %q transport\n", transport)
+
+		nw := run(transport, "flooding", nil)
+
+		stats, err := nw.Stats("msg")
+		if err != nil {
+			t.Fatalf("failed to compute stats: %v", err)
+		}
+		if stats.Coverage != 1 || nw.TransportErrors() != 0 {
+			t.Fatalf("expected full coverage without transport errors, got %+v (%d errors)", stats, nw.TransportErrors())
+		}
+		for _, arrival := range stats.Arrivals[1:] {
+			if arrival < 6 {
+				t.Fatalf("expected every arrival after at least one hop of injected latency, got %f", arrival)
+			}
+		}
+
+		if gossip := run(transport, "gossip", map[string]any{"gossip_factor": 1.0}); gossip.Reachability("msg") != 1 {
+			t.Fatalf("expected gossip with a factor of 1 to reach every peer")
+		}
+	}
+
+	fmt.Println("- Test protocol registry")
+
+	custom := func(id p2p.PeerID, msg p2p.Message, neighbors, sent, received []p2p.PeerID, static, dynamic map[string]any) (*[]p2p.PeerID, map[p2p.PeerID]map[string]any) {
+		return p2p.Flooding(id, msg, neighbors, sent, received, static, dynamic)
+	}
+
+	newNetwork := func(named map[string]p2p.ProtocolFunc) (*p2p.P2P, error) {
+		return p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+			Transport:             p2p.TransportTCP,
+			NamedProtocols:        named,
+		})
+	}
+
+	if _, err := newNetwork(map[string]p2p.ProtocolFunc{"flooding": custom}); err == nil {
+		t.Fatalf("expected error for duplicate protocol name")
+	}
+
+	nw, err := newNetwork(map[string]p2p.ProtocolFunc{"custom": custom})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+	defer nw.Free()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	nw.Run(ctx)
+
+	if err := nw.Publish("0", "unnamed", custom, nil, nil); err == nil {
+		t.Fatalf("expected error for a protocol without a name")
+	}
+	if err := nw.PublishMessage("0", p2p.Message{Content: "unknown", ProtocolName: "unknown"}); err == nil {
+		t.Fatalf("expected error for an unregistered protocol")
+	}
+	if err := nw.PublishMessage("0", p2p.Message{Content: "custom", ProtocolName: "custom"}); err != nil {
+		t.Fatalf("failed to publish message with registered protocol: %v", err)
+	}
+
+	nw.ExpireSimulation(cancel, "custom", 200*time.Millisecond, 10*time.Second, 10*time.Millisecond)
+
+	if nw.Reachability("custom") != 1 {
+		t.Fatalf("expected the registered protocol to reach every peer")
+	}
+
+	if _, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 5 },
+		Transport:             p2p.TransportUDP,
+		SimulatedClock:        true,
+	}); err == nil {
+		t.Fatalf("expected error for socket transport on a simulated clock")
+	}
+}
+
+// TestLatencyModels verifies the latency distributions, the distance-based model over generated coordinates
+// and the empirical region matrix.
+func TestLatencyModels(t *testing.T) {
+	fmt.Println("Test Latency Models")
+
+	fmt.Println("- Test distributions")
+
+	if p2p.ConstantLatency(7)("a", "b") != 7 {
+		t.Fatalf("expected constant latency")
+	}
+
+	models := map[string]p2p.LatencyFunc{
+		"uniform":    p2p.UniformLatency(10, 20, 1),
+		"normal":     p2p.NormalLatency(50, 10, 1),
+		"log-normal": p2p.LogNormalLatency(50, 0.5, 1),
+	}
+
+	for name, model := range models {
+		sum := 0.0
+		for i := 0; i < 1000; i++ {
+			a, b := p2p.PeerID(fmt.Sprintf("%d", i)), p2p.PeerID(fmt.Sprintf("%d", i+1))
+
+			latency := model(a, b)
+			if latency < 0 || latency != model(b, a) {
+				t.Fatalf("expected symmetric non-negative %s latency, got %f and %f", name, latency, model(b, a))
+			}
+			if name == "uniform" && (latency < 10 || latency > 20) {
+				t.Fatalf("expected uniform latency in [10, 20], got %f", latency)
+			}
+
+			sum += latency
+		}
+
+		if mean := sum / 1000; mean < 14 || mean > 60 {
+			t.Fatalf("unexpected mean %s latency %f", name, mean)
+		}
+	}
+
+	if p2p.UniformLatency(10, 20, 1)("0", "1") == p2p.UniformLatency(10, 20, 2)("0", "1") {
+		t.Fatalf("expected different latencies with different seeds")
+	}
+
+	fmt.Println("- Test distance latency")
+
+	rgg, err := standard.RandomGeometricGraph(1, false, nil, 100, 0.2)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+	waxman, err := standard.WaxmanGraph(1, false, nil, 100, 0.4, 0.2)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	// neighbors in a random geometric graph are within the radius, and all nodes lie in the unit square
+	for g, radius := range map[*graph.Graph]float64{rgg: 0.2, waxman: math.Sqrt2} {
+		model, err := p2p.DistanceLatency(g, 5, 100)
+		if err != nil {
+			t.Fatalf("failed to create distance latency: %v", err)
+		}
+
+		for _, id := range g.Nodes() {
+			node, _ := g.Node(id)
+			for _, neighbor := range node.Neighbors() {
+				if latency := model(p2p.PeerID(id), p2p.PeerID(neighbor)); latency < 5 || latency > 5+100*radius+1e-9 {
+					t.Fatalf("expected latency between 5 and %f, got %f", 5+100*radius, latency)
+				}
+			}
+		}
+	}
+
+	if _, err := p2p.DistanceLatency(lineGraph(t, 3), 5, 100); err == nil {
+		t.Fatalf("expected error for nodes without coordinates")
+	}
+
+	fmt.Println("- Test region matrix")
+
+	matrix, err := p2p.LoadRegionMatrix(strings.NewReader(",eu,us,asia\neu,10,80,200\nus,90,10,150\nasia,200,150,10\n"))
+	if err != nil {
+		t.Fatalf("failed to load region matrix: %v", err)
+	}
+
+	regions, err := matrix.AssignRegions([]p2p.PeerID{"0", "1", "2", "3"}, map[string]float64{"eu": 1, "us": 1}, 1)
+	if err != nil {
+		t.Fatalf("failed to assign regions: %v", err)
+	}
+	for id, region := range regions {
+		if region == "asia" {
+			t.Fatalf("expected peer %s not to be assigned to a region without weight", id)
+		}
+	}
+
+	if _, err := matrix.AssignRegions([]p2p.PeerID{"0"}, map[string]float64{"eu": 0}, 1); err == nil {
+		t.Fatalf("expected error when no region has a positive weight")
+	}
+	if _, err := matrix.AssignRegions([]p2p.PeerID{"0"}, map[string]float64{"eu": 1, "us": -1}, 1); err == nil {
+		t.Fatalf("expected error for a negative weight")
+	}
+
+	model := matrix.Latency(map[p2p.PeerID]string{"a": "eu", "b": "us"})
+	if model("a", "b") != 40 || model("b", "a") != 45 || model("a", "a") != 5 {
+		t.Fatalf("expected half round-trip latencies, got %f, %f and %f", model("a", "b"), model("b", "a"), model("a", "a"))
+	}
+
+	if _, err := p2p.LoadRegionMatrix(strings.NewReader(",eu,us\neu,10,x\nus,10,10\n")); err == nil {
+		t.Fatalf("expected error for invalid round-trip time")
+	}
+}
+
+// limitedPayload is a test payload that limits how far a message spreads.
+type limitedPayload struct {
+	MaxHops int
+}
+
+// Validate rejects negative hop limits.
+func (l limitedPayload) Validate() error {
+	if l.MaxHops < 0 {
+		return fmt.Errorf("negative hop limit %d", l.MaxHops)
+	}
+
+	return nil
+}
+
+// TestTypedProtocol verifies typed parameters and payloads, and the validation of protocol parameters at publish time.
+func TestTypedProtocol(t *testing.T) {
+	fmt.Println("Test Typed Protocol")
+
+	newNetwork := func(g *graph.Graph) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+			SimulatedClock:        true,
+			Seed:                  1,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		return nw
+	}
+
+	g, err := standard.ErdosRenyiGraph(1, false, nil, 100, 0.1)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	fmt.Println("- Test gossip parameters")
+
+	nw := newNetwork(g)
+
+	// a whole gossip factor given as an int is a factor of 1, not the default factor
+	if err := nw.Publish("0", "int", p2p.Gossip, map[string]any{"gossip_factor": 1}, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if err := nw.Publish("0", "nodes", p2p.Gossip, map[string]any{"gossip_node": 2.0}, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+
+	for _, params := range []map[string]any{
+		{"gossip_factor": "0.5"},
+		{"gossip_factor": 1.5},
+		{"gossip_node": 1.5},
+		{"gossip_node": -1},
+		{"gossip_factor": 0.5, "gossip_node": 2},
+	} {
+		if err := nw.Publish("0", "invalid", p2p.Gossip, params, nil); err == nil {
+			t.Fatalf("expected error for parameters %v", params)
+		}
+	}
+
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	if reach := nw.Reachability("int"); reach != 1 {
+		t.Fatalf("expected a gossip factor of 1 to reach every peer, got %f", reach)
+	}
+	if stats, _ := nw.Stats("nodes"); stats.Sent > 2*stats.Reached {
+		t.Fatalf("expected every peer to gossip to at most 2 neighbors, got %d sends for %d peers", stats.Sent, stats.Reached)
+	}
+
+	// invalid parameters that bypass Publish do not fall back to the default factor
+	adapted, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    func(src, dst p2p.PeerID) float64 { return 10 },
+		SimulatedClock:        true,
+		Protocol:              p2p.FuncProtocol(p2p.Gossip, map[string]any{"gossip_factor": 2.0}),
+	})
+	if err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+
+	adapted.Run(context.Background())
+
+	if err := adapted.Publish("0", "adapted", nil, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := adapted.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+	if reach := adapted.Reachability("adapted"); reach != 0.01 {
+		t.Fatalf("expected invalid parameters to forward to no one, got reachability %f", reach)
+	}
+
+	fmt.Println("- Test typed gossip")
+
+	if err := p2p.PublishTyped(nw, "0", "typed", any(nil), p2p.TypedGossip, p2p.GossipParams{Factor: 1}); err != nil {
+		t.Fatalf("failed to publish typed message: %v", err)
+	}
+	if err := p2p.PublishTyped(nw, "0", "invalid", any(nil), p2p.TypedGossip, p2p.GossipParams{Factor: 2}); err == nil {
+		t.Fatalf("expected error for a gossip factor above 1")
+	}
+
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	if reach := nw.Reachability("typed"); reach != 1 {
+		t.Fatalf("expected typed gossip with a factor of 1 to reach every peer, got %f", reach)
+	}
+
+	fmt.Println("- Test typed payload")
+
+	limited := p2p.NewTypedProtocol(func(id p2p.PeerID, msg p2p.Message, payload limitedPayload, neighbors, sentPeers, receivedPeers []p2p.PeerID, params struct{}) []p2p.PeerID {
+		if msg.HopCount >= payload.MaxHops {
+			return nil
+		}
+
+		targets, _ := p2p.Flooding(id, msg, neighbors, sentPeers, receivedPeers, nil, nil)
+
+		return *targets
+	})
+
+	line := newNetwork(lineGraph(t, 6))
+
+	if err := p2p.PublishTyped(line, "0", "limited", limitedPayload{MaxHops: 3}, limited, struct{}{}); err != nil {
+		t.Fatalf("failed to publish typed message: %v", err)
+	}
+	if err := p2p.PublishTyped(line, "0", "negative", limitedPayload{MaxHops: -1}, limited, struct{}{}); err == nil {
+		t.Fatalf("expected error for an invalid payload")
+	}
+
+	if _, err := line.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	if reached := len(line.FirstMessageReceptions("limited")); reached != 4 {
+		t.Fatalf("expected the payload to limit the message to 3 hops, got %d peers", reached)
+	}
+}
+
+// countingProtocol is a test stateful protocol that floods messages, counts every reception,
+// ticks a few times and records the neighbors that went down.
+type countingProtocol struct {
+	peer     *p2p.PeerHandle
+	received map[string]int
+	ticks    int
+	down     []p2p.PeerID
+}
+
+// Init starts the ticks.
+func (c *countingProtocol) Init(peer *p2p.PeerHandle) {
+	c.peer = peer
+	c.received = make(map[string]int)
+	c.peer.SetTimer("tick", 10)
+}
+
+// OnReceive counts the reception and floods the first one to the neighbors other than the sender.
+func (c *countingProtocol) OnReceive(msg p2p.Message, first bool) {
+	c.received[msg.ID]++
+
+	if !first {
+		return
+	}
+
+	targets := make([]p2p.PeerID, 0)
+	for _, neighbor := range c.peer.Neighbors() {
+		if neighbor != msg.From {
+			targets = append(targets, neighbor)
+		}
+	}
+
+	c.peer.Forward(msg, targets)
+}
+
+// OnTimer ticks five times.
+func (c *countingProtocol) OnTimer(name string) {
+	if c.ticks++; c.ticks < 5 {
+		c.peer.SetTimer(name, 10)
+	}
+}
+
+// OnPeerDown records the neighbor.
+func (c *countingProtocol) OnPeerDown(id p2p.PeerID) {
+	c.down = append(c.down, id)
+}
+
+// TestStatefulProtocol verifies per-peer protocol instances with their lifecycle hooks, and the adapter for protocol functions.
+func TestStatefulProtocol(t *testing.T) {
+	fmt.Println("Test Stateful Protocol")
+
+	g, err := standard.ErdosRenyiGraph(2, false, nil, 100, 0.08)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	newNetwork := func(protocol func(id p2p.PeerID) p2p.Protocol) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    p2p.UniformLatency(5, 50, 1),
+			SimulatedClock:        true,
+			Protocol:              protocol,
+			Seed:                  1,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		return nw
+	}
+
+	fmt.Println("- Test adapters")
+
+	for name, fn := range map[string]p2p.ProtocolFunc{"flooding": p2p.Flooding, "gossip": p2p.Gossip} {
+		nw := newNetwork(p2p.FuncProtocol(fn, map[string]any{"gossip_factor": 1.0}))
+
+		if err := nw.Publish("0", "msg", nil, nil, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		if reach := nw.Reachability("msg"); reach != 1 {
+			t.Fatalf("expected adapted %s to reach every peer, got %f", name, reach)
+		}
+	}
+
+	fmt.Println("- Test lifecycle hooks")
+
+	instances := make(map[p2p.PeerID]*countingProtocol)
+	nw := newNetwork(func(id p2p.PeerID) p2p.Protocol {
+		instances[id] = &countingProtocol{}
+		return instances[id]
+	})
+
+	if err := nw.Publish("0", "msg", nil, nil, nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	if _, err := nw.RunUntilQuiescent(); err != nil {
+		t.Fatalf("failed to run simulation: %v", err)
+	}
+
+	if reach := nw.Reachability("msg"); reach != 1 {
+		t.Fatalf("expected the stateful protocol to reach every peer, got %f", reach)
+	}
+
+	duplicates := 0
+	for id, c := range instances {
+		if c.ticks != 5 {
+			t.Fatalf("expected peer %s to tick 5 times, got %d", id, c.ticks)
+		}
+
+		duplicates += c.received["msg"] - 1
+	}
+
+	if duplicates != nw.DuplicateMessageCount("msg") {
+		t.Fatalf("expected instances to see %d duplicates, got %d", nw.DuplicateMessageCount("msg"), duplicates)
+	}
+
+	fmt.Println("- Test peer down")
+
+	neighbors, _ := nw.Neighbors("5")
+
+	if err := nw.Crash("5"); err != nil {
+		t.Fatalf("failed to crash peer: %v", err)
+	}
+	if err := nw.RemovePeer("5"); err != nil {
+		t.Fatalf("failed to remove peer: %v", err)
+	}
+
+	for id, c := range instances {
+		expected := 0
+		if slices.Contains(neighbors, id) {
+			expected = 2
+		}
+
+		if len(c.down) != expected {
+			t.Fatalf("expected peer %s to be notified %d times, got %v", id, expected, c.down)
+		}
+	}
+
+	if _, err := p2p.New(g, &p2p.Config{
+		ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+		NetworkLatencyFunc:    p2p.ConstantLatency(10),
+		Plumtree:              &p2p.PlumtreeConfig{GraftTimeout: 100},
+		Protocol:              p2p.FuncProtocol(p2p.Flooding, nil),
+	}); err == nil {
+		t.Fatalf("expected error for a stateful protocol combined with plumtree")
+	}
+}
+
+// TestTTL verifies hop limits and time to live enforced by the network, and their accounting in the statistics.
+func TestTTL(t *testing.T) {
+	fmt.Println("Test TTL")
+
+	newNetwork := func(g *graph.Graph, protocol func(id p2p.PeerID) p2p.Protocol) *p2p.P2P {
+		nw, err := p2p.New(g, &p2p.Config{
+			ProcessingLatencyFunc: func(src p2p.PeerID) float64 { return 1 },
+			NetworkLatencyFunc:    p2p.ConstantLatency(10),
+			SimulatedClock:        true,
+			Protocol:              protocol,
+		})
+		if err != nil {
+			t.Fatalf("failed to create network: %v", err)
+		}
+
+		nw.Run(context.Background())
+
+		return nw
+	}
+
+	run := func(nw *p2p.P2P, msg p2p.Message) *p2p.MessageStats {
+		if err := nw.PublishMessage("0", msg); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+		if _, err := nw.RunUntilQuiescent(); err != nil {
+			t.Fatalf("failed to run simulation: %v", err)
+		}
+
+		stats, err := nw.Stats(msg.ID)
+		if err != nil {
+			t.Fatalf("failed to compute stats: %v", err)
+		}
+
+		return stats
+	}
+
+	fmt.Println("- Test hop limit")
+
+	line := newNetwork(lineGraph(t, 10), nil)
+
+	stats := run(line, p2p.Message{ID: "scoped", Content: "scoped", Protocol: p2p.Flooding, MaxHops: 3})
+	if stats.Reached != 4 || stats.Sent != 3 || stats.HopLimited != 1 || stats.Expired != 0 {
+		t.Fatalf("expected the message to stop after 3 hops, got %+v", stats)
+	}
+
+	adapted := newNetwork(lineGraph(t, 10), p2p.FuncProtocol(p2p.Flooding, nil))
+
+	if stats := run(adapted, p2p.Message{ID: "scoped", Content: "scoped", MaxHops: 3}); stats.Reached != 4 {
+		t.Fatalf("expected the stateful protocol to stop after 3 hops, got %d peers", stats.Reached)
+	}
+
+	fmt.Println("- Test expanding ring")
+
+	g, err := standard.BarabasiAlbertGraph(1, false, nil, 500, 2)
+	if err != nil {
+		t.Fatalf("failed to generate graph: %v", err)
+	}
+
+	nw := newNetwork(g, nil)
+
+	// with constant latencies the first reception follows a shortest path, so each ring reaches the peers within its radius
+	dist := map[p2p.PeerID]int{"0": 0}
+	frontier := []p2p.PeerID{"0"}
+	for len(frontier) > 0 {
+		next := make([]p2p.PeerID, 0)
+		for _, id := range frontier {
+			neighbors, _ := nw.Neighbors(id)
+			for _, neighbor := range neighbors {
+				if _, ok := dist[neighbor]; !ok {
+					dist[neighbor] = dist[id] + 1
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	previous := 0
+	for radius := 1; radius <= 3; radius++ {
+		within := 0
+		for _, d := range dist {
+			if d <= radius {
+				within++
+			}
+		}
+
+		ring := run(nw, p2p.Message{ID: fmt.Sprintf("ring-%d", radius), Protocol: p2p.Flooding, MaxHops: radius})
+		if ring.Reached != within || ring.Reached <= previous {
+			t.Fatalf("expected ring %d to reach %d peers, got %d", radius, within, ring.Reached)
+		}
+
+		previous = ring.Reached
+	}
+
+	aggregate, err := nw.AggregateStats()
+	if err != nil {
+		t.Fatalf("failed to aggregate stats: %v", err)
+	}
+	if aggregate.HopLimited == 0 {
+		t.Fatalf("expected hop-limited peers in the aggregate, got %+v", aggregate)
+	}
+
+	fmt.Println("- Test time to live")
+
+	// hops arrive 11, 22 and 33 milliseconds after publishing
+	stats = run(line, p2p.Message{ID: "timed", Content: "timed", Protocol: p2p.Flooding, TTL: 25})
+	if stats.Reached != 3 || stats.Expired != 1 || line.ExpiredMessageCount("timed") != 1 {
+		t.Fatalf("expected the message to expire before the third hop, got %+v", stats)
+	}
+
+	events, err := line.PeerLog("3", "timed")
+	if err != nil {
+		t.Fatalf("failed to get peer log: %v", err)
+	}
+	if evs := events["timed"]; len(evs) != 1 || evs[0].Type != p2p.EventExpire {
+		t.Fatalf("expected an expire event at peer 3, got %v", evs)
+	}
+
+	if err := line.PublishMessage("0", p2p.Message{Content: "invalid", Protocol: p2p.Flooding, MaxHops: -1}); err == nil {
+		t.Fatalf("expected error for a negative hop limit")
+	}
+}
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"context"
+	"slices"
 	"sync"
 	"time"
 )
@@ -12,36 +13,45 @@ type peer struct {
 	processingLatency float64         // latency for processing a message at the source peer, in milliseconds
 	edges             map[PeerID]edge // connections to other peers, mapping target peer ID to edge information
 
-	recvFrom  map[string]map[PeerID]struct{} // content -> set of senders
-	sentTo    map[string]map[PeerID]struct{} // content -> set of targets
-	seenAt    map[string]time.Time           // content -> first arrival time
-	firstFrom map[string]PeerID              // content -> first sender
-
-	msgQueue chan Message // channel for incoming messages
-	mu       sync.Mutex   // mutex to protect access to the peer's state
-
-	alive bool // indicates whether the peer is active in the network
-
-	log map[string][]logEntry
+	recvFrom  map[string]map[PeerID]struct{} // message ID -> set of senders
+	sentTo    map[string]map[PeerID]struct{} // message ID -> set of targets
+	seenAt    map[string]time.Time           // message ID -> first arrival time
+	firstFrom map[string]PeerID              // message ID -> first sender
+	firstHop  map[string]int                 // message ID -> hop count at first arrival
+	messages  map[string]Message             // message ID -> message as first received, resent by built-in protocols
+	expired   map[string]int                 // message ID -> number of arrivals dropped because the TTL had passed
+
+	uploadBandwidth   float64   // upload bandwidth in megabits per second, zero if unlimited
+	downloadBandwidth float64   // download bandwidth in megabits per second, zero if unlimited
+	uploadFreeAt      time.Time // time at which the upload link finishes its queued transmissions
+	downloadFreeAt    time.Time // time at which the download link finishes its queued receptions
+
+	msgQueue chan Message  // channel for incoming messages
+	done     chan struct{} // closed when the peer is stopped, to release its routine and any blocked sender
+	dropped  int           // number of messages dropped because the message queue was full
+	mu       sync.Mutex    // mutex to protect access to the peer's state
+
+	alive   bool // indicates whether the peer is active in the network
+	stopped bool // indicates whether the peer has been stopped and no longer handles messages
+
+	topics   map[string]struct{} // topics the peer subscribes to
+	behavior Behavior            // misbehavior applied to every message the peer sends, nil for an honest peer
+	handlers []handler           // built-in protocols enabled by the network configuration, with this peer's state
+
+	log       map[string][]TraceEvent // message ID -> events recorded by this peer
+	unwritten []TraceEvent            // events recorded but not yet passed to Config.TraceWriter
 }
 
 // edge represents a connection from one node to another in the P2P network.
 type edge struct {
 	targetID       PeerID  // ID of the target peer
 	networkLatency float64 // latency for a message sent from this peer to the target peer, in milliseconds
+	bandwidth      float64 // bandwidth of the link in megabits per second, zero if limited only by the peers
+	down           bool    // indicates whether the link has failed and drops every message sent over it
 }
 
-type logEntry struct {
-	ID        PeerID `json:"id"`        // ID of the peer
-	Timestamp string `json:"timestamp"` // timestamp of the log entry
-	Type      string `json:"type"`      // type of log entry (e.g., "recv", "send")
-	From      PeerID `json:"from"`      // ID of the sender peer
-	To        PeerID `json:"to"`        // ID of the target peer
-	First     bool   `json:"first"`     // indicates if this is the first time the message is seen
-}
-
-// newPeer creates a new Node with the given ID and node latency.
-func newPeer(id PeerID, nodeLatency float64) *peer {
+// newPeer creates a new Node with the given ID, node latency and message queue capacity.
+func newPeer(id PeerID, nodeLatency float64, capacity int) *peer {
 	return &peer{
 		id:                id,
 		processingLatency: nodeLatency,
@@ -51,11 +61,16 @@ func newPeer(id PeerID, nodeLatency float64) *peer {
 		sentTo:    make(map[string]map[PeerID]struct{}),
 		seenAt:    make(map[string]time.Time),
 		firstFrom: make(map[string]PeerID),
+		firstHop:  make(map[string]int),
+		messages:  make(map[string]Message),
+		expired:   make(map[string]int),
 
-		msgQueue: make(chan Message, 1000),
+		msgQueue: make(chan Message, capacity),
+		done:     make(chan struct{}),
 		mu:       sync.Mutex{},
 
-		log: make(map[string][]logEntry),
+		topics: make(map[string]struct{}),
+		log:    make(map[string][]TraceEvent),
 	}
 }
 
@@ -75,73 +90,120 @@ func (p *peer) eachRun(network *P2P, wg *sync.WaitGroup, ctx context.Context) {
 			p.mu.Unlock()
 			return
 		default:
-			for msg := range p.msgQueue {
-				first := false
-
-				p.mu.Lock()
-				if _, ok := p.recvFrom[msg.Content]; !ok {
-					p.recvFrom[msg.Content] = make(map[PeerID]struct{})
+			for {
+				select {
+				case msg := <-p.msgQueue:
+					p.handle(network, msg)
+				case <-p.done:
+					return
 				}
-				p.recvFrom[msg.Content][msg.From] = struct{}{}
+			}
+		}
+	}(ctx, wg)
+}
 
-				if _, ok := p.log[msg.Content]; !ok {
-					p.log[msg.Content] = make([]logEntry, 0)
-				}
+// handle records the reception of a message and, if it is the first time the message is seen,
+// schedules forwarding after the peer's processing latency. Control messages are only passed to the peer's handlers.
+// Messages whose TTL has passed are dropped before anything else sees them.
+func (p *peer) handle(network *P2P, msg Message) {
+	if !p.isAlive() {
+		return
+	}
 
-				if _, ok := p.seenAt[msg.Content]; !ok {
-					p.seenAt[msg.Content] = time.Now()
-					p.firstFrom[msg.Content] = msg.From
-					first = true
-
-					p.log[msg.Content] = append(p.log[msg.Content], logEntry{
-						ID:        p.id,
-						Timestamp: timestamp(),
-						Type:      "recv",
-						From:      msg.From,
-						To:        p.id,
-						First:     true,
-					})
-				} else {
-					p.log[msg.Content] = append(p.log[msg.Content], logEntry{
-						ID:        p.id,
-						Timestamp: timestamp(),
-						Type:      "recv",
-						From:      msg.From,
-						To:        p.id,
-						First:     false,
-					})
-				}
-				p.mu.Unlock()
+	if msg.Kind == KindPayload && network.expired(msg) {
+		p.expire(network, msg)
+		return
+	}
 
-				if first {
-					go func(msg Message) {
-						currentTime := time.Now()
-						p.eachPublish(network, msg, currentTime)
-					}(msg)
-				}
-			}
+	if msg.Kind != KindPayload {
+		p.receiveControl(network, msg)
+
+		for _, h := range p.handlers {
+			h.receive(network, p, msg, false)
 		}
-	}(ctx, wg)
+
+		return
+	}
+
+	first := p.receive(network, msg)
+	if first {
+		network.fireTriggers(p.id, msg)
+	}
+
+	for _, h := range p.handlers {
+		h.receive(network, p, msg, first)
+	}
+
+	if first && msg.Protocol != nil && !(msg.Stem && network.cfg.Dandelion != nil) && !hopLimited(msg) {
+		network.after(p.processingLatency, func() {
+			p.eachPublish(network, msg)
+		})
+	}
+}
+
+// receive records the reception of a message and reports whether it is the first time the message is seen.
+func (p *peer) receive(network *P2P, msg Message) bool {
+	first := false
+	now := network.now()
+
+	defer network.writeTrace(p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.recvFrom[msg.ID]; !ok {
+		p.recvFrom[msg.ID] = make(map[PeerID]struct{})
+	}
+	p.recvFrom[msg.ID][msg.From] = struct{}{}
+
+	if _, ok := p.seenAt[msg.ID]; !ok {
+		p.seenAt[msg.ID] = now
+		p.firstFrom[msg.ID] = msg.From
+		p.firstHop[msg.ID] = msg.HopCount
+		p.messages[msg.ID] = msg
+		first = true
+	}
+
+	network.record(p, TraceEvent{
+		Type:    EventRecv,
+		From:    msg.From,
+		To:      p.id,
+		ID:      msg.ID,
+		Content: msg.Content,
+		Hop:     msg.HopCount,
+		Time:    now,
+		First:   first,
+	})
+
+	return first
 }
 
 // eachPublish sends the message to neighbors, excluding 'exclude' and already-sent targets.
-func (p *peer) eachPublish(network *P2P, msg Message, start time.Time) {
-	content := msg.Content
+// The message is dropped instead if its TTL passed during processing.
+func (p *peer) eachPublish(network *P2P, msg Message) {
+	if network.expired(msg) {
+		p.expire(network, msg)
+		return
+	}
+
+	id := msg.ID
 	protocol := msg.Protocol
 	hopCount := msg.HopCount
 
-	delay := time.Duration(p.processingLatency * float64(time.Millisecond))
-	if remain := delay - time.Since(start); remain > 0 {
-		time.Sleep(remain)
-	}
+	defer network.writeTrace(p)
 
 	p.mu.Lock()
 
-	if _, ok := p.sentTo[content]; !ok {
-		p.sentTo[content] = make(map[PeerID]struct{})
+	if !p.alive {
+		p.mu.Unlock()
+		return
+	}
+
+	if _, ok := p.sentTo[id]; !ok {
+		p.sentTo[id] = make(map[PeerID]struct{})
 	}
-	if _, ok := p.recvFrom[content]; !ok {
-		p.recvFrom[content] = make(map[PeerID]struct{})
+	if _, ok := p.recvFrom[id]; !ok {
+		p.recvFrom[id] = make(map[PeerID]struct{})
 	}
 
 	willSendEdges := make([]edge, 0)
@@ -152,15 +214,20 @@ func (p *peer) eachPublish(network *P2P, msg Message, start time.Time) {
 	}
 
 	sentEdges := make([]PeerID, 0)
-	for targetID := range p.sentTo[content] {
+	for targetID := range p.sentTo[id] {
 		sentEdges = append(sentEdges, targetID)
 	}
 
 	receivedEdges := make([]PeerID, 0)
-	for senderID := range p.recvFrom[content] {
+	for senderID := range p.recvFrom[id] {
 		receivedEdges = append(receivedEdges, senderID)
 	}
 
+	// sort the neighbor lists so that protocols see the same input on every run
+	slices.Sort(allEdges)
+	slices.Sort(sentEdges)
+	slices.Sort(receivedEdges)
+
 	targets, dynamicParams := msg.Protocol(p.id, msg, allEdges, sentEdges, receivedEdges, msg.StaticParams, msg.DynamicParams)
 
 	if dynamicParams == nil {
@@ -168,76 +235,107 @@ func (p *peer) eachPublish(network *P2P, msg Message, start time.Time) {
 	}
 
 	for _, targetID := range *targets {
-		for _, edge := range p.edges {
-			if edge.targetID == targetID {
-				willSendEdges = append(willSendEdges, edge)
-				break
-			}
+		if edge, ok := p.edges[targetID]; ok {
+			willSendEdges = append(willSendEdges, edge)
 		}
 	}
 
+	now := network.now()
+	fwd := p.forwarder()
+
+	forwarded := make([]Message, 0)
+	forwardedTo := make([]PeerID, 0)
+
 	for _, e := range willSendEdges {
 		edgeCopy := e
-		p.sentTo[content][e.targetID] = struct{}{}
 
-		if _, ok := p.log[msg.Content]; !ok {
-			p.log[msg.Content] = make([]logEntry, 0)
-		}
+		var dynamics map[string]any
 
-		p.log[content] = append(p.log[content], logEntry{
-			ID:        p.id,
-			Timestamp: timestamp(),
-			Type:      "send",
-			From:      p.id,
-			To:        e.targetID,
-			First:     false,
-		})
-
-		go func(e edge) {
-			time.Sleep(time.Duration(e.networkLatency * float64(time.Millisecond)))
+		if _, ok := dynamicParams[edgeCopy.targetID]; !ok {
+			dynamics = nil
+		} else {
+			dynamics = make(map[string]any)
 
-			targetPeer, ok := network.peers[e.targetID]
-			if !ok || targetPeer == nil || !targetPeer.alive {
-				return
+			for k, v := range dynamicParams[edgeCopy.targetID] {
+				dynamics[k] = v
 			}
+		}
 
-			var dynamics map[string]any
+		out := msg
+		out.From = p.id
+		out.Protocol = protocol
+		out.HopCount = hopCount + 1
+		out.DynamicParams = dynamics
+		out.Rand = network.rng
+
+		if fwd != nil || p.behavior != nil {
+			forwarded = append(forwarded, out)
+			forwardedTo = append(forwardedTo, e.targetID)
+			continue
+		}
 
-			if _, ok := dynamicParams[e.targetID]; !ok {
-				dynamics = nil
-			} else {
-				dynamics = make(map[string]any)
+		p.sentTo[id][e.targetID] = struct{}{}
 
-				for k, v := range dynamicParams[e.targetID] {
-					dynamics[k] = v
-				}
-			}
+		network.record(p, TraceEvent{
+			Type:    EventSend,
+			From:    p.id,
+			To:      e.targetID,
+			ID:      id,
+			Content: msg.Content,
+			Hop:     hopCount + 1,
+			Time:    now,
+		})
 
-			network.peers[e.targetID].msgQueue <- Message{
-				Publisher:     msg.Publisher,
-				From:          p.id,
-				Content:       content,
-				Protocol:      protocol,
-				HopCount:      hopCount + 1,
-				StaticParams:  msg.StaticParams,
-				DynamicParams: dynamics,
-			}
-		}(edgeCopy)
+		p.transmit(network, edgeCopy, out)
 	}
 
 	p.mu.Unlock()
+
+	for i, out := range forwarded {
+		if fwd != nil {
+			fwd.forward(network, p, forwardedTo[i], out)
+		} else {
+			p.send(network, forwardedTo[i], out)
+		}
+	}
 }
 
-// eachStop marks the peer as inactive and closes its message queue.
+// eachStop marks the peer as inactive and stops its message handling routine.
 func (p *peer) eachStop() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.stopped {
+		return
+	}
+
 	p.alive = false
-	close(p.msgQueue)
+	p.stopped = true
+	close(p.done)
+}
+
+// isStopped reports whether the peer has been stopped and will never run again.
+func (p *peer) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stopped
 }
 
-// timestamp returns the current time formatted as a string for logging purposes.
-func timestamp() string {
-	return time.Now().Format("2006-01-02 15:04:05.000")
+// isAlive reports whether the peer is active in the network.
+func (p *peer) isAlive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.alive
+}
+
+// linkUp reports whether the peer still has a working link to the target peer.
+func (p *peer) linkUp(targetID PeerID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.edges[targetID]
+
+	return ok && !e.down
 }
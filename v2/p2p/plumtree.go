@@ -0,0 +1,231 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PlumtreeConfig enables Plumtree, epidemic broadcast trees. Every peer pushes the messages it receives for the
+// first time eagerly, in full, to its eager peers, and lazily, as IHAVE metadata, to its lazy peers. Initially
+// every neighbor is eager. A peer that receives a duplicate moves the sender to its lazy peers and sends it a
+// PRUNE, which makes the sender do the same, so that the eager links converge to a spanning tree. A peer that
+// learns about a message through IHAVE and does not receive it within GraftTimeout sends a GRAFT to the
+// announcing peer, which sends the message and adds the link back to the tree.
+//
+// Plumtree disseminates the messages published with a nil protocol and no Topic.
+type PlumtreeConfig struct {
+	GraftTimeout float64 // time to wait for a message announced with IHAVE before grafting, in milliseconds; must be positive
+	GraftRetry   float64 // time to wait after a GRAFT before grafting the next announcing peer, in milliseconds; zero means half of GraftTimeout
+	LazyDelay    float64 // delay of IHAVE metadata after the eager push, in milliseconds
+}
+
+// announcement is a peer that announced a missing message with IHAVE.
+type announcement struct {
+	msgID string
+	from  PeerID
+}
+
+// plumtree is the per-peer handler of Plumtree.
+type plumtree struct {
+	cfg *PlumtreeConfig
+
+	lazy     map[PeerID]struct{} // lazy peers; every other neighbor is eager
+	missing  []announcement      // IHAVE announcements of messages not received yet, in order of arrival
+	grafting map[string]bool     // message ID -> whether a graft timer is running
+	mu       sync.Mutex          // mutex to protect access to the handler's state
+}
+
+// newPlumtree creates a Plumtree handler whose neighbors are all eager.
+func newPlumtree(cfg *PlumtreeConfig) *plumtree {
+	return &plumtree{
+		cfg:      cfg,
+		lazy:     make(map[PeerID]struct{}),
+		missing:  make([]announcement, 0),
+		grafting: make(map[string]bool),
+	}
+}
+
+// start does nothing; the tree is built by the first broadcasts.
+func (t *plumtree) start(network *P2P, p *peer) {}
+
+// receive builds the tree from payload messages and handles the control messages of Plumtree.
+func (t *plumtree) receive(network *P2P, p *peer, msg Message, first bool) {
+	switch msg.Kind {
+	case KindPayload:
+		if msg.Protocol != nil || msg.Topic != "" {
+			return
+		}
+
+		if !first {
+			t.mu.Lock()
+			t.lazy[msg.From] = struct{}{}
+			t.mu.Unlock()
+
+			p.send(network, msg.From, Message{Kind: KindPrune})
+			return
+		}
+
+		t.mu.Lock()
+		if msg.From != p.id {
+			delete(t.lazy, msg.From)
+		}
+		t.forget(msg.ID)
+		t.mu.Unlock()
+
+		network.after(p.processingLatency, func() {
+			t.push(network, p, msg)
+		})
+
+	case KindPrune:
+		t.mu.Lock()
+		t.lazy[msg.From] = struct{}{}
+		t.mu.Unlock()
+
+	case KindIHave:
+		for _, msgID := range msg.Digest {
+			if p.hasSeen(msgID) {
+				continue
+			}
+
+			t.mu.Lock()
+			t.missing = append(t.missing, announcement{msgID: msgID, from: msg.From})
+			running := t.grafting[msgID]
+			t.grafting[msgID] = true
+			t.mu.Unlock()
+
+			if !running {
+				t.wait(network, p, msgID, t.cfg.GraftTimeout)
+			}
+		}
+
+	case KindGraft:
+		t.mu.Lock()
+		delete(t.lazy, msg.From)
+		t.mu.Unlock()
+
+		for _, msgID := range msg.Digest {
+			p.mu.Lock()
+			out, ok := p.messages[msgID]
+			p.mu.Unlock()
+
+			if ok {
+				out.HopCount++
+				out.DynamicParams = nil
+
+				p.send(network, msg.From, out)
+			}
+		}
+	}
+}
+
+// push sends the message to the eager peers and IHAVE metadata to the lazy peers, except the sender.
+func (t *plumtree) push(network *P2P, p *peer, msg Message) {
+	eager := make([]PeerID, 0)
+	lazy := make([]PeerID, 0)
+
+	t.mu.Lock()
+	for _, id := range p.neighborIDs() {
+		if id == msg.From {
+			continue
+		}
+
+		if _, ok := t.lazy[id]; ok {
+			lazy = append(lazy, id)
+		} else {
+			eager = append(eager, id)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, id := range eager {
+		out := msg
+		out.HopCount++
+		out.DynamicParams = nil
+
+		p.send(network, id, out)
+	}
+
+	network.after(t.cfg.LazyDelay, func() {
+		for _, id := range lazy {
+			p.send(network, id, Message{Kind: KindIHave, Digest: []string{msg.ID}})
+		}
+	})
+}
+
+// wait grafts the first peer that announced the message if it has not been received after the delay,
+// and keeps waiting for the next announcing peer.
+func (t *plumtree) wait(network *P2P, p *peer, msgID string, delay float64) {
+	network.after(delay, func() {
+		if p.hasSeen(msgID) || !p.isAlive() {
+			t.mu.Lock()
+			t.forget(msgID)
+			t.mu.Unlock()
+			return
+		}
+
+		t.mu.Lock()
+
+		var from PeerID
+		found := false
+
+		for i, a := range t.missing {
+			if a.msgID == msgID {
+				from, found = a.from, true
+				t.missing = append(t.missing[:i], t.missing[i+1:]...)
+				break
+			}
+		}
+
+		if !found {
+			delete(t.grafting, msgID)
+			t.mu.Unlock()
+			return
+		}
+
+		delete(t.lazy, from)
+		t.mu.Unlock()
+
+		p.send(network, from, Message{Kind: KindGraft, Digest: []string{msgID}})
+
+		retry := t.cfg.GraftRetry
+		if retry == 0 {
+			retry = t.cfg.GraftTimeout / 2
+		}
+
+		t.wait(network, p, msgID, retry)
+	})
+}
+
+// forget drops the announcements and the graft timer state of a message. The caller must hold the handler's mutex.
+func (t *plumtree) forget(msgID string) {
+	remaining := t.missing[:0]
+	for _, a := range t.missing {
+		if a.msgID != msgID {
+			remaining = append(remaining, a)
+		}
+	}
+
+	t.missing = remaining
+	delete(t.grafting, msgID)
+}
+
+// LazyPeers returns the sorted IDs of the neighbors the peer pushes messages to lazily with Plumtree.
+// Every other neighbor belongs to the peer's eager peers, the links of the broadcast tree.
+// It returns an error if the peer does not exist or Plumtree is not enabled.
+func (p *P2P) LazyPeers(id PeerID) ([]PeerID, error) {
+	n, ok := p.peer(id)
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", id)
+	}
+
+	for _, h := range n.handlers {
+		if t, ok := h.(*plumtree); ok {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			return sortedIDs(t.lazy), nil
+		}
+	}
+
+	return nil, fmt.Errorf("plumtree is not enabled")
+}
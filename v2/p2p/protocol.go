@@ -1,7 +1,10 @@
 package p2p
 
 import (
+	"fmt"
+	"math"
 	"math/rand/v2"
+	"reflect"
 	"slices"
 )
 
@@ -9,17 +12,46 @@ import (
 type Message struct {
 	Publisher     PeerID         // ID of the peer that originally published the message
 	From          PeerID         // ID of the peer that sent the message to the current peer
+	ID            string         // unique identifier of the message, set to Content at publish time if empty
 	Content       string         // the actual content of the message
+	Size          int            // size of the message in bytes, used to compute transmission delays over limited bandwidth
 	HopCount      int            // the number of hops the message has taken from the publisher to the current peer
 	Protocol      ProtocolFunc   // the protocol function that determines how the message should be processed and forwarded
+	ProtocolName  string         // name of the protocol in the network's registry, which replaces Protocol at publish time if set
 	StaticParams  map[string]any // additional parameters for the protocol function
 	DynamicParams map[string]any // additional parameters that can change during message processing
+	Rand          *rand.Rand     // seeded random source of the network, to be used by protocols for any random choice
+	Kind          MessageKind    // kind of the message, KindPayload for messages that carry Content
+	Digest        []string       // IDs of the messages known by the sender, carried by digest messages
+	Topic         string         // topic of the message, for protocols that disseminate messages per topic
+	Stem          bool           // indicates whether the message is in the stem phase of Dandelion++; ignored unless Config.Dandelion is set
+	Payload       any            // typed payload of the message, set by PublishTyped
+	MaxHops       int            // hop limit of the message: peers that receive it at this hop count do not forward it; zero means unlimited
+	TTL           float64        // time to live of the message in milliseconds since publication, after which it is dropped on arrival; zero means unlimited
 }
 
+// MessageKind distinguishes messages that carry content from the control messages exchanged by built-in protocols.
+type MessageKind string
+
+const (
+	KindPayload     MessageKind = ""             // the message carries Content and is forwarded by its ProtocolFunc
+	KindDigest      MessageKind = "digest"       // anti-entropy digest sent by the peer that starts a round
+	KindDigestReply MessageKind = "digest_reply" // anti-entropy digest sent back by the contacted peer
+	KindAnnounce    MessageKind = "announce"     // announcement of the message ID, sent instead of the message itself (INV)
+	KindRequest     MessageKind = "request"      // request for an announced message (GETDATA)
+	KindSubscribe   MessageKind = "subscribe"    // notification that the sender subscribed to Topic
+	KindUnsubscribe MessageKind = "unsubscribe"  // notification that the sender unsubscribed from Topic
+	KindGraft       MessageKind = "graft"        // request to add the sender to the receiver's mesh or tree
+	KindPrune       MessageKind = "prune"        // notification that the sender removed the receiver from its mesh or tree
+	KindIHave       MessageKind = "ihave"        // metadata listing IDs of messages the sender has, carried in Digest
+	KindIWant       MessageKind = "iwant"        // request for messages advertised with IHAVE, carried in Digest
+)
+
 // ProtocolFunc defines the function signature for custom protocols in the P2P network.
 // It takes the current peer's ID, the message being processed, a list of neighbor IDs,
 // lists of peers the message has been sent to and received from, and any additional static parameters.
 // It returns a pointer to a slice of PeerIDs that the message should be forwarded to, along with any dynamic parameters.
+// Protocols that make random choices should draw from msg.Rand, which is seeded from Config.Seed, so that runs are reproducible.
 type ProtocolFunc func(id PeerID, msg Message, neighbors []PeerID, sentPeers []PeerID, receivedPeers []PeerID, staticParams, dynamicParams map[string]any) (*[]PeerID, map[PeerID]map[string]any)
 
 // Flooding is a simple broadcast protocol where each peer forwards the message to all its neighbors except those it has already sent to or received from.
@@ -40,8 +72,49 @@ var Flooding ProtocolFunc = func(id PeerID, msg Message, neighbors []PeerID, sen
 }
 
 // Gossip is a broadcast protocol where each peer forwards the message to a random subset of its neighbors, determined by the gossip factor.
+// Its static parameters are "gossip_factor", the fraction of the remaining neighbors to forward to, or "gossip_node", their number,
+// which are checked by Publish; without either, a factor of 0.5 is used. Invalid parameters that reach a peer without
+// being published, such as those of a FuncProtocol adapter, forward the message to no one. TypedGossip takes them as
+// GossipParams instead.
 var Gossip ProtocolFunc = func(id PeerID, msg Message, neighbors []PeerID, sentPeers []PeerID, receivedPeers []PeerID, staticParams, dynamicParams map[string]any) (*[]PeerID, map[PeerID]map[string]any) {
 	targets := make([]PeerID, 0)
+
+	params, err := gossipParams(staticParams)
+	if err != nil {
+		return &targets, nil
+	}
+
+	targets = gossip(msg, neighbors, sentPeers, receivedPeers, params)
+
+	return &targets, nil
+}
+
+// GossipParams are the typed parameters of Gossip.
+type GossipParams struct {
+	Factor float64 // fraction of the remaining neighbors to forward to, between 0 and 1
+	Nodes  int     // number of remaining neighbors to forward to; if positive, it is used instead of Factor
+}
+
+// Validate checks that the factor is between 0 and 1 and the number of nodes is not negative.
+func (g GossipParams) Validate() error {
+	if g.Factor < 0 || g.Factor > 1 {
+		return fmt.Errorf("gossip factor must be between 0 and 1, got %v", g.Factor)
+	}
+	if g.Nodes < 0 {
+		return fmt.Errorf("number of gossip nodes must not be negative, got %d", g.Nodes)
+	}
+
+	return nil
+}
+
+// TypedGossip is Gossip with typed parameters, to be published with PublishTyped. It carries any payload.
+var TypedGossip = NewTypedProtocol(func(id PeerID, msg Message, payload any, neighbors, sentPeers, receivedPeers []PeerID, params GossipParams) []PeerID {
+	return gossip(msg, neighbors, sentPeers, receivedPeers, params)
+})
+
+// gossip returns a random subset of the neighbors the message was neither sent to nor received from.
+func gossip(msg Message, neighbors, sentPeers, receivedPeers []PeerID, params GossipParams) []PeerID {
+	targets := make([]PeerID, 0)
 	for _, neighbor := range neighbors {
 		if slices.Contains(sentPeers, neighbor) {
 			continue
@@ -53,31 +126,111 @@ var Gossip ProtocolFunc = func(id PeerID, msg Message, neighbors []PeerID, sentP
 		targets = append(targets, neighbor)
 	}
 
-	gossipFactor, ok1 := staticParams["gossip_factor"].(float64)
-	gossipNode, ok2 := staticParams["gossip_node"].(int)
-
-	if !ok1 && !ok2 {
-		ok1 = true
-		gossipFactor = 0.5
-	}
-
 	if len(targets) > 0 {
-		rand.Shuffle(len(targets), func(i, j int) {
+		shuffle := rand.Shuffle
+		if msg.Rand != nil {
+			shuffle = msg.Rand.Shuffle
+		}
+
+		shuffle(len(targets), func(i, j int) {
 			targets[i], targets[j] = targets[j], targets[i]
 		})
 
-		if ok1 {
-			k := int(float64(len(targets)) * gossipFactor)
-			targets = targets[:k]
-		} else if ok2 {
-			k := gossipNode
-			if k > len(targets) {
-				k = len(targets)
-			}
-			
-			targets = targets[:k]
+		if params.Nodes > 0 {
+			targets = targets[:min(params.Nodes, len(targets))]
+		} else {
+			targets = targets[:int(float64(len(targets))*params.Factor)]
 		}
 	}
 
+	return targets
+}
+
+// gossipParams reads the parameters of Gossip from its static parameters. Numbers of any numeric type are accepted,
+// as long as the number of nodes is a whole number.
+func gossipParams(staticParams map[string]any) (GossipParams, error) {
+	factor, hasFactor := staticParams["gossip_factor"]
+	nodes, hasNodes := staticParams["gossip_node"]
+
+	params := GossipParams{Factor: 0.5}
+
+	switch {
+	case hasFactor && hasNodes:
+		return params, fmt.Errorf("gossip_factor and gossip_node cannot both be set")
+	case hasFactor:
+		f, ok := number(factor)
+		if !ok {
+			return params, fmt.Errorf("gossip_factor must be a number, got %T", factor)
+		}
+
+		params.Factor = f
+	case hasNodes:
+		n, ok := number(nodes)
+		if !ok || n != math.Trunc(n) {
+			return params, fmt.Errorf("gossip_node must be a whole number, got %v", nodes)
+		}
+		if n == 0 {
+			// no node is selected, rather than falling back to the factor
+			params.Factor = 0
+		}
+
+		params.Nodes = int(n)
+	}
+
+	return params, params.Validate()
+}
+
+// number converts a value of any numeric type to a float64.
+func number(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int()), true
+	case rv.CanUint():
+		return float64(rv.Uint()), true
+	case rv.CanFloat():
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// Pull is a protocol that forwards a message to no one, so that it only spreads through the anti-entropy rounds
+// configured by Config.AntiEntropy.
+var Pull ProtocolFunc = func(id PeerID, msg Message, neighbors []PeerID, sentPeers []PeerID, receivedPeers []PeerID, staticParams, dynamicParams map[string]any) (*[]PeerID, map[PeerID]map[string]any) {
+	targets := make([]PeerID, 0)
+
 	return &targets, nil
 }
+
+/* Registry */
+
+// builtinProtocols are the protocols every network knows by name, along with those of Config.NamedProtocols.
+var builtinProtocols = map[string]ProtocolFunc{
+	"flooding": Flooding,
+	"gossip":   Gossip,
+	"pull":     Pull,
+}
+
+// newRegistry returns the protocols known by name to a network: the built-in ones and the named ones of its
+// configuration, which must have non-empty names distinct from the built-in ones.
+func newRegistry(named map[string]ProtocolFunc) (map[string]ProtocolFunc, error) {
+	registry := make(map[string]ProtocolFunc, len(builtinProtocols)+len(named))
+	for name, protocol := range builtinProtocols {
+		registry[name] = protocol
+	}
+
+	for name, protocol := range named {
+		if name == "" || protocol == nil {
+			return nil, fmt.Errorf("protocol name and function are required")
+		}
+		if _, ok := registry[name]; ok {
+			return nil, fmt.Errorf("protocol %s already registered", name)
+		}
+
+		registry[name] = protocol
+	}
+
+	return registry, nil
+}
@@ -0,0 +1,31 @@
+package p2p
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// lockedSource wraps a rand.Source with a mutex so that a single seeded source can be shared by concurrently running peers.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+// Uint64 returns the next pseudo-random value from the underlying source.
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.src.Uint64()
+}
+
+// newRand creates a goroutine-safe random generator deterministically derived from the given seed.
+func newRand(seed uint64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)})
+}
+
+// NewRand creates a goroutine-safe random generator deterministically derived from the given seed, in the same way as
+// the random source of a network, for packages that simulate on top of it.
+func NewRand(seed uint64) *rand.Rand {
+	return newRand(seed)
+}
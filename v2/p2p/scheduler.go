@@ -0,0 +1,130 @@
+package p2p
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// simulationEpoch is the wall-clock instant that virtual time zero maps to, so that virtual
+// timestamps can be stored and reported as time.Time values.
+var simulationEpoch = time.Unix(0, 0).UTC()
+
+// scheduler is a discrete-event scheduler that advances a virtual clock instead of sleeping.
+// Events are executed in order of their virtual time; events scheduled for the same instant
+// are executed in the order they were scheduled, which keeps runs reproducible.
+type scheduler struct {
+	now   time.Duration // current virtual time, measured from simulationEpoch
+	seq   uint64        // sequence number of the next scheduled event, used for tie-breaking
+	queue eventQueue    // pending events ordered by virtual time
+	mu    sync.Mutex    // mutex to protect access to the clock and the queue
+}
+
+// event is a callback scheduled to run at a specific virtual time.
+type event struct {
+	at  time.Duration // virtual time at which the event fires
+	seq uint64        // insertion order, used to break ties between events with the same time
+	fn  func()        // callback executed when the event fires
+}
+
+// eventQueue implements heap.Interface as a min-heap of events ordered by time and insertion order.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+
+	return e
+}
+
+// newScheduler creates a scheduler whose virtual clock starts at zero.
+func newScheduler() *scheduler {
+	return &scheduler{
+		queue: make(eventQueue, 0),
+	}
+}
+
+// schedule registers fn to run after the given delay of virtual time. Negative delays are treated as zero.
+func (s *scheduler) schedule(delay time.Duration, fn func()) {
+	if delay < 0 {
+		delay = 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	heap.Push(&s.queue, &event{at: s.now + delay, seq: s.seq, fn: fn})
+	s.seq++
+}
+
+// step pops the earliest pending event, advances the clock to its time and executes it.
+// It returns false if there was no pending event.
+func (s *scheduler) step() bool {
+	s.mu.Lock()
+
+	if len(s.queue) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+
+	e := heap.Pop(&s.queue).(*event)
+	s.now = e.at
+	s.mu.Unlock()
+
+	e.fn()
+
+	return true
+}
+
+// runUntil executes every event scheduled up to the given virtual time, then advances the clock to that time.
+func (s *scheduler) runUntil(deadline time.Duration) {
+	for {
+		s.mu.Lock()
+
+		if len(s.queue) == 0 || s.queue[0].at > deadline {
+			if deadline > s.now {
+				s.now = deadline
+			}
+
+			s.mu.Unlock()
+			return
+		}
+
+		s.mu.Unlock()
+
+		s.step()
+	}
+}
+
+// elapsed returns the current virtual time measured from the start of the simulation.
+func (s *scheduler) elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.now
+}
+
+// pending returns the number of events waiting to be executed.
+func (s *scheduler) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue)
+}
@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Protocol is a stateful protocol running at a single peer. Unlike a ProtocolFunc, which is called once per message
+// at its first reception, a Protocol instance lives as long as its peer, so that it can keep per-peer state such as
+// routing tables, meshes or scores, react to duplicates and act on timers. Every peer gets its own instance from
+// Config.Protocol, and calls to an instance are never concurrent.
+type Protocol interface {
+	// Init is called once when the peer starts running, with the handle the instance acts through.
+	Init(peer *PeerHandle)
+	// OnReceive is called for every message handled by the live peer. first reports whether the message is the
+	// first reception of its message ID, and is always false for control messages.
+	OnReceive(msg Message, first bool)
+	// OnTimer is called when a timer set with PeerHandle.SetTimer expires while the peer is alive.
+	OnTimer(name string)
+	// OnPeerDown is called when a neighbor of the peer crashes or is removed from the network.
+	OnPeerDown(id PeerID)
+}
+
+// PeerHandle is the view of a peer given to its Protocol instance.
+type PeerHandle struct {
+	network *P2P
+	peer    *peer
+}
+
+// ID returns the ID of the peer.
+func (h *PeerHandle) ID() PeerID {
+	return h.peer.id
+}
+
+// Neighbors returns the sorted IDs of the peers the peer has a link to.
+func (h *PeerHandle) Neighbors() []PeerID {
+	return h.peer.neighborIDs()
+}
+
+// Now returns the current time of the network, virtual on a simulated clock.
+func (h *PeerHandle) Now() time.Time {
+	return h.network.now()
+}
+
+// Rand returns the seeded random source of the network, to be used for any random choice.
+func (h *PeerHandle) Rand() *rand.Rand {
+	return h.network.rng
+}
+
+// Seen reports whether the peer has received the message with the given ID.
+func (h *PeerHandle) Seen(msgID string) bool {
+	return h.peer.hasSeen(msgID)
+}
+
+// SentTo returns the sorted IDs of the peers the message with the given ID has been sent to.
+func (h *PeerHandle) SentTo(msgID string) []PeerID {
+	h.peer.mu.Lock()
+	defer h.peer.mu.Unlock()
+
+	return sortedIDs(h.peer.sentTo[msgID])
+}
+
+// ReceivedFrom returns the sorted IDs of the peers the message with the given ID has been received from.
+func (h *PeerHandle) ReceivedFrom(msgID string) []PeerID {
+	h.peer.mu.Lock()
+	defer h.peer.mu.Unlock()
+
+	return sortedIDs(h.peer.recvFrom[msgID])
+}
+
+// Send transmits a message to a neighbor immediately, as is. Messages of a custom MessageKind can be used to
+// exchange control information between instances. It returns false if the message was dropped, the peer is not alive
+// or it has no link to the target.
+func (h *PeerHandle) Send(targetID PeerID, msg Message) bool {
+	return h.peer.send(h.network, targetID, msg)
+}
+
+// Forward sends a message to the targets after the peer's processing latency, one hop further than it was received,
+// like the targets chosen by a ProtocolFunc. Nothing is sent if the message has reached its hop limit.
+func (h *PeerHandle) Forward(msg Message, targets []PeerID) {
+	if hopLimited(msg) {
+		return
+	}
+
+	msg.HopCount++
+	targets = slices.Clone(targets)
+
+	h.network.after(h.peer.processingLatency, func() {
+		if !h.peer.isAlive() {
+			return
+		}
+
+		fwd := h.peer.forwarder()
+
+		for _, targetID := range targets {
+			if fwd != nil && msg.Kind == KindPayload {
+				out := msg
+				out.From = h.peer.id
+				out.Rand = h.network.rng
+
+				fwd.forward(h.network, h.peer, targetID, out)
+			} else {
+				h.peer.send(h.network, targetID, msg)
+			}
+		}
+	})
+}
+
+// SetTimer calls OnTimer with the name after the given delay in milliseconds, unless the peer is crashed or stopped
+// by then. Timers are one-shot; periodic behavior is obtained by setting the timer again from OnTimer.
+func (h *PeerHandle) SetTimer(name string, delay float64) {
+	h.network.after(delay, func() {
+		if h.peer.isStopped() || !h.peer.isAlive() {
+			return
+		}
+
+		if ph := h.peer.protocolHandler(); ph != nil {
+			ph.timer(name)
+		}
+	})
+}
+
+/* Handler */
+
+// protocolHandler runs the Protocol instance of a peer as a handler.
+type protocolHandler struct {
+	protocol Protocol
+	mu       sync.Mutex // serializes the calls to the instance
+}
+
+// start initializes the instance.
+func (h *protocolHandler) start(network *P2P, p *peer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.protocol.Init(&PeerHandle{network: network, peer: p})
+}
+
+// receive passes every message handled by the peer to the instance.
+func (h *protocolHandler) receive(network *P2P, p *peer, msg Message, first bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.protocol.OnReceive(msg, first)
+}
+
+// timer passes an expired timer to the instance.
+func (h *protocolHandler) timer(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.protocol.OnTimer(name)
+}
+
+// peerDown notifies the instance that a neighbor went down.
+func (h *protocolHandler) peerDown(id PeerID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.protocol.OnPeerDown(id)
+}
+
+// protocolHandler returns the handler running the peer's Protocol instance, or nil.
+func (p *peer) protocolHandler() *protocolHandler {
+	for _, h := range p.handlers {
+		if ph, ok := h.(*protocolHandler); ok {
+			return ph
+		}
+	}
+
+	return nil
+}
+
+// notifyPeerDown calls OnPeerDown on the live peers that have a link to the peer that went down.
+func (p *P2P) notifyPeerDown(id PeerID) {
+	if p.cfg.Protocol == nil {
+		return
+	}
+
+	for _, n := range p.peerList() {
+		if n.id == id || !n.isAlive() {
+			continue
+		}
+
+		n.mu.Lock()
+		_, linked := n.edges[id]
+		n.mu.Unlock()
+
+		if !linked {
+			continue
+		}
+
+		if ph := n.protocolHandler(); ph != nil {
+			ph.peerDown(id)
+		}
+	}
+}
+
+/* Adapters */
+
+// funcProtocol runs a ProtocolFunc as a Protocol.
+type funcProtocol struct {
+	peer         *PeerHandle
+	fn           ProtocolFunc
+	staticParams map[string]any
+}
+
+// FuncProtocol adapts a ProtocolFunc, such as Flooding or Gossip, to a Protocol for Config.Protocol: at the first
+// reception of a payload message, the function chooses the targets, which receive the message with the dynamic
+// parameters returned for them. Messages should be published with a nil protocol, so that they are forwarded only by
+// the adapter, and the function receives the given static parameters.
+func FuncProtocol(fn ProtocolFunc, staticParams map[string]any) func(id PeerID) Protocol {
+	return func(id PeerID) Protocol {
+		return &funcProtocol{fn: fn, staticParams: staticParams}
+	}
+}
+
+// Init stores the handle of the peer.
+func (f *funcProtocol) Init(peer *PeerHandle) {
+	f.peer = peer
+}
+
+// OnReceive forwards the first reception of a payload message to the targets chosen by the function.
+func (f *funcProtocol) OnReceive(msg Message, first bool) {
+	if !first || msg.Kind != KindPayload {
+		return
+	}
+
+	targets, dynamicParams := f.fn(f.peer.ID(), msg, f.peer.Neighbors(), f.peer.SentTo(msg.ID), f.peer.ReceivedFrom(msg.ID), f.staticParams, msg.DynamicParams)
+	if targets == nil {
+		return
+	}
+
+	for _, targetID := range *targets {
+		out := msg
+		out.DynamicParams = dynamicParams[targetID]
+
+		f.peer.Forward(out, []PeerID{targetID})
+	}
+}
+
+// OnTimer does nothing, as functions do not set timers.
+func (f *funcProtocol) OnTimer(name string) {}
+
+// OnPeerDown does nothing, as functions keep no state about neighbors.
+func (f *funcProtocol) OnPeerDown(id PeerID) {}
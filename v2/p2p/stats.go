@@ -0,0 +1,235 @@
+package p2p
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// publication records who published a message and when.
+type publication struct {
+	publisher PeerID
+	at        time.Time
+	maxHops   int // hop limit of the message, zero if unlimited
+}
+
+// MessageStats summarizes the propagation of a single message, so that protocols are compared with one consistent definition.
+// Latencies are measured in milliseconds from the publish time to the first reception at each peer; the publisher itself is
+// counted for coverage but excluded from the latency percentiles.
+type MessageStats struct {
+	ID          string    `json:"id"`           // ID of the message
+	Publisher   PeerID    `json:"publisher"`    // peer that published the message
+	PublishedAt time.Time `json:"published_at"` // time at which the message was published
+
+	Peers    int     `json:"peers"`    // number of peers in the network
+	Reached  int     `json:"reached"`  // number of peers that received the message, including the publisher
+	Coverage float64 `json:"coverage"` // fraction of peers that received the message
+
+	LatencyP50 float64 `json:"latency_p50"` // median first-reception latency, in milliseconds
+	LatencyP90 float64 `json:"latency_p90"` // 90th percentile first-reception latency, in milliseconds
+	LatencyP99 float64 `json:"latency_p99"` // 99th percentile first-reception latency, in milliseconds
+	LatencyMax float64 `json:"latency_max"` // maximum first-reception latency, in milliseconds
+
+	Hops map[int]int `json:"hops"` // hop count at first reception -> number of peers
+
+	Sent       int     `json:"sent"`       // total number of times the message was sent over a link
+	Duplicates int     `json:"duplicates"` // total number of receptions beyond the first at each peer
+	Redundancy float64 `json:"redundancy"` // relative message redundancy, Sent / (Reached - 1) - 1; zero if no peer beyond the publisher was reached
+
+	Expired    int `json:"expired"`     // number of times the message was dropped because its TTL had passed
+	HopLimited int `json:"hop_limited"` // number of peers that received the message at its hop limit and did not forward it
+
+	Arrivals   []float64          `json:"arrivals"`    // sorted first-reception latencies of all reached peers including the publisher, in milliseconds
+	ArrivalsOf map[PeerID]float64 `json:"arrivals_of"` // reached peer -> first-reception latency, in milliseconds
+}
+
+// Stats computes the propagation statistics of the specified message.
+// It returns an error if the message was never published in this network.
+func (p *P2P) Stats(msg string) (*MessageStats, error) {
+	p.mu.RLock()
+	pub, ok := p.published[msg]
+	p.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("message %s was not published", msg)
+	}
+
+	stats := &MessageStats{
+		ID:          msg,
+		Publisher:   pub.publisher,
+		PublishedAt: pub.at,
+		Hops:        make(map[int]int),
+		Arrivals:    make([]float64, 0),
+		ArrivalsOf:  make(map[PeerID]float64),
+	}
+
+	latencies := make([]float64, 0)
+
+	for _, peer := range p.peerList() {
+		stats.Peers++
+
+		peer.mu.Lock()
+
+		stats.Sent += len(peer.sentTo[msg])
+		stats.Expired += peer.expired[msg]
+
+		if t, ok := peer.seenAt[msg]; ok {
+			latency := float64(t.Sub(pub.at)) / float64(time.Millisecond)
+
+			stats.Reached++
+			stats.Hops[peer.firstHop[msg]]++
+			stats.Duplicates += len(peer.recvFrom[msg]) - 1
+			stats.Arrivals = append(stats.Arrivals, latency)
+			stats.ArrivalsOf[peer.id] = latency
+
+			if pub.maxHops > 0 && peer.firstHop[msg] >= pub.maxHops {
+				stats.HopLimited++
+			}
+
+			if peer.id != pub.publisher {
+				latencies = append(latencies, latency)
+			}
+		}
+
+		peer.mu.Unlock()
+	}
+
+	slices.Sort(stats.Arrivals)
+	slices.Sort(latencies)
+
+	if stats.Peers > 0 {
+		stats.Coverage = float64(stats.Reached) / float64(stats.Peers)
+	}
+
+	stats.LatencyP50 = Percentile(latencies, 0.50)
+	stats.LatencyP90 = Percentile(latencies, 0.90)
+	stats.LatencyP99 = Percentile(latencies, 0.99)
+	stats.LatencyMax = Percentile(latencies, 1)
+
+	if stats.Reached > 1 {
+		stats.Redundancy = float64(stats.Sent)/float64(stats.Reached-1) - 1
+	}
+
+	return stats, nil
+}
+
+// TimeToCoverage returns the time in milliseconds after publishing at which the given fraction of all peers had received
+// the message. It returns false if that coverage was never reached.
+func (s *MessageStats) TimeToCoverage(fraction float64) (float64, bool) {
+	if fraction <= 0 {
+		return 0, true
+	}
+
+	needed := int(math.Ceil(fraction * float64(s.Peers)))
+	if needed > len(s.Arrivals) {
+		return 0, false
+	}
+
+	return s.Arrivals[needed-1], true
+}
+
+// AggregateStats summarizes the propagation of all messages of a run. Latencies are pooled over the first receptions
+// of every message, excluding the publishers.
+type AggregateStats struct {
+	Messages     int     `json:"messages"`      // number of messages
+	FullCoverage int     `json:"full_coverage"` // number of messages that reached every peer
+	MeanCoverage float64 `json:"mean_coverage"` // mean fraction of peers reached per message
+	MinCoverage  float64 `json:"min_coverage"`  // lowest fraction of peers reached by a message
+
+	LatencyMean float64 `json:"latency_mean"` // mean first-reception latency, in milliseconds
+	LatencyP50  float64 `json:"latency_p50"`  // median first-reception latency, in milliseconds
+	LatencyP90  float64 `json:"latency_p90"`  // 90th percentile first-reception latency, in milliseconds
+	LatencyP99  float64 `json:"latency_p99"`  // 99th percentile first-reception latency, in milliseconds
+	LatencyMax  float64 `json:"latency_max"`  // maximum first-reception latency, in milliseconds
+
+	Sent           int     `json:"sent"`            // total number of times a message was sent over a link
+	Duplicates     int     `json:"duplicates"`      // total number of receptions beyond the first at each peer
+	MeanRedundancy float64 `json:"mean_redundancy"` // mean relative message redundancy per message
+
+	Expired    int `json:"expired"`     // total number of times a message was dropped because its TTL had passed
+	HopLimited int `json:"hop_limited"` // total number of peers that received a message at its hop limit
+}
+
+// Aggregate combines the statistics of several messages.
+func Aggregate(stats []*MessageStats) *AggregateStats {
+	a := &AggregateStats{}
+	latencies := make([]float64, 0)
+
+	for _, s := range stats {
+		if a.Messages == 0 || s.Coverage < a.MinCoverage {
+			a.MinCoverage = s.Coverage
+		}
+
+		a.Messages++
+		a.MeanCoverage += s.Coverage
+		a.MeanRedundancy += s.Redundancy
+		a.Sent += s.Sent
+		a.Duplicates += s.Duplicates
+		a.Expired += s.Expired
+		a.HopLimited += s.HopLimited
+
+		if s.Reached == s.Peers {
+			a.FullCoverage++
+		}
+
+		// the publisher is excluded by ID, as it may have been removed from the network since
+		for id, latency := range s.ArrivalsOf {
+			if id != s.Publisher {
+				latencies = append(latencies, latency)
+			}
+		}
+	}
+
+	if a.Messages > 0 {
+		a.MeanCoverage /= float64(a.Messages)
+		a.MeanRedundancy /= float64(a.Messages)
+	}
+
+	// sort first so that the mean is summed in the same order on every run
+	slices.Sort(latencies)
+
+	for _, l := range latencies {
+		a.LatencyMean += l
+	}
+	if len(latencies) > 0 {
+		a.LatencyMean /= float64(len(latencies))
+	}
+
+	a.LatencyP50 = Percentile(latencies, 0.50)
+	a.LatencyP90 = Percentile(latencies, 0.90)
+	a.LatencyP99 = Percentile(latencies, 0.99)
+	a.LatencyMax = Percentile(latencies, 1)
+
+	return a
+}
+
+// AggregateStats computes the statistics of every message published in the network and combines them.
+func (p *P2P) AggregateStats() (*AggregateStats, error) {
+	stats := make([]*MessageStats, 0)
+
+	for _, msg := range p.Messages() {
+		s, err := p.Stats(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, s)
+	}
+
+	return Aggregate(stats), nil
+}
+
+// Percentile returns the q-th quantile of sorted values using the nearest-rank method, or zero for an empty slice.
+func Percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
@@ -0,0 +1,245 @@
+package p2p
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/elecbug/netkit/v2/graph"
+)
+
+/* Peers */
+
+// AddPeer adds a new, unconnected peer to the network. If the network is already running,
+// the peer is started immediately; otherwise it is started by Run like every other peer.
+func (p *P2P) AddPeer(id PeerID) error {
+	p.mu.Lock()
+
+	if _, ok := p.peers[id]; ok {
+		p.mu.Unlock()
+		return fmt.Errorf("peer %s already exists", id)
+	}
+
+	n := newPeer(id, p.cfg.ProcessingLatencyFunc(id), queueCapacity(p.cfg.QueueCapacity))
+	n.uploadBandwidth = bandwidthOf(p.cfg.UploadBandwidthFunc, id)
+	n.downloadBandwidth = bandwidthOf(p.cfg.DownloadBandwidthFunc, id)
+	n.behavior = behaviorOf(p.cfg.BehaviorFunc, id)
+	n.handlers = p.newHandlers(id)
+
+	if p.transport != nil {
+		if err := p.transport.open(p, id); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+	}
+
+	p.peers[id] = n
+
+	running, ctx := p.running, p.ctx
+	p.mu.Unlock()
+
+	if !running {
+		return nil
+	}
+
+	if p.sched != nil {
+		n.mu.Lock()
+		n.alive = true
+		n.mu.Unlock()
+	} else {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		n.eachRun(p, wg, ctx)
+		wg.Wait()
+	}
+
+	n.startHandlers(p)
+
+	return nil
+}
+
+// RemovePeer stops a peer, removes all of its links and deletes it from the network.
+// Messages in flight to the removed peer are dropped, and the stateful protocols of its neighbors are notified.
+func (p *P2P) RemovePeer(id PeerID) error {
+	p.mu.Lock()
+
+	n, ok := p.peers[id]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("peer %s not found", id)
+	}
+
+	delete(p.peers, id)
+
+	others := make([]*peer, 0, len(p.peers))
+	for _, other := range p.peers {
+		others = append(others, other)
+	}
+
+	p.mu.Unlock()
+
+	// neighbors are notified while they still have their link to the removed peer
+	p.notifyPeerDown(id)
+
+	for _, other := range others {
+		other.mu.Lock()
+		delete(other.edges, id)
+		other.mu.Unlock()
+	}
+
+	n.eachStop()
+
+	n.mu.Lock()
+	n.edges = make(map[PeerID]edge)
+	n.mu.Unlock()
+
+	if p.transport != nil {
+		p.transport.close(id)
+	}
+
+	return nil
+}
+
+/* Links */
+
+// Connect creates a link from a to b, and from b to a unless the network was built from a directed graph.
+// The latency of each new link is generated by Config.NetworkLatencyFunc.
+func (p *P2P) Connect(a, b PeerID) error {
+	if a == b {
+		return fmt.Errorf("cannot connect peer %s to itself", a)
+	}
+	if p.cfg.NetworkLatencyFunc == nil {
+		return fmt.Errorf("network latency function is required to connect peers")
+	}
+
+	peerA, ok := p.peer(a)
+	if !ok {
+		return fmt.Errorf("peer %s not found", a)
+	}
+	peerB, ok := p.peer(b)
+	if !ok {
+		return fmt.Errorf("peer %s not found", b)
+	}
+
+	peerA.mu.Lock()
+	if _, exists := peerA.edges[b]; exists {
+		peerA.mu.Unlock()
+		return fmt.Errorf("link between %s and %s already exists", a, b)
+	}
+
+	peerA.edges[b] = edge{targetID: b, networkLatency: p.cfg.NetworkLatencyFunc(a, b)}
+	peerA.mu.Unlock()
+
+	if !p.directed {
+		peerB.mu.Lock()
+		peerB.edges[a] = edge{targetID: a, networkLatency: p.cfg.NetworkLatencyFunc(b, a)}
+		peerB.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Disconnect removes the link from a to b, and from b to a unless the network was built from a directed graph.
+// Messages already in flight over the removed link are dropped.
+func (p *P2P) Disconnect(a, b PeerID) error {
+	if err := p.checkLink(a, b); err != nil {
+		return err
+	}
+
+	peerA, _ := p.peer(a)
+	peerB, _ := p.peer(b)
+
+	peerA.mu.Lock()
+	delete(peerA.edges, b)
+	peerA.mu.Unlock()
+
+	if !p.directed {
+		peerB.mu.Lock()
+		delete(peerB.edges, a)
+		peerB.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Neighbors returns the sorted IDs of the peers the given peer has a link to.
+func (p *P2P) Neighbors(id PeerID) ([]PeerID, error) {
+	n, ok := p.peer(id)
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", id)
+	}
+
+	return n.neighborIDs(), nil
+}
+
+/* Export */
+
+// Graph returns an unweighted snapshot of the current topology, including failed links and crashed peers,
+// which can be passed to the analyzer to compute metrics on the overlay as it evolves.
+func (p *P2P) Graph() (*graph.Graph, error) {
+	g := graph.New(p.directed, false)
+
+	peers := p.peerList()
+
+	for _, n := range peers {
+		if err := g.AddNode(graph.NodeID(n.id)); err != nil {
+			return nil, fmt.Errorf("failed to add node %s: %v", n.id, err)
+		}
+	}
+
+	for _, n := range peers {
+		n.mu.Lock()
+		targets := make([]PeerID, 0, len(n.edges))
+		for targetID := range n.edges {
+			targets = append(targets, targetID)
+		}
+		n.mu.Unlock()
+
+		slices.Sort(targets)
+
+		for _, targetID := range targets {
+			from, to := graph.NodeID(n.id), graph.NodeID(targetID)
+
+			if !g.HasNode(to) || g.HasEdge(from, to) {
+				continue
+			}
+
+			if err := g.AddEdge(from, to, nil); err != nil {
+				return nil, fmt.Errorf("failed to add edge from %s to %s: %v", from, to, err)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+/* Utility Functions */
+
+// peer returns the peer with the given ID.
+func (p *P2P) peer(id PeerID) (*peer, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n, ok := p.peers[id]
+
+	return n, ok
+}
+
+// peerList returns a snapshot of all peers sorted by ID, so that iteration order is deterministic.
+func (p *P2P) peerList() []*peer {
+	p.mu.RLock()
+
+	peers := make([]*peer, 0, len(p.peers))
+	for _, n := range p.peers {
+		peers = append(peers, n)
+	}
+
+	p.mu.RUnlock()
+
+	slices.SortFunc(peers, func(a, b *peer) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	return peers
+}
@@ -0,0 +1,281 @@
+package p2p
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of a trace event.
+type EventType string
+
+const (
+	EventSend   EventType = "send"   // a peer sent a message to a neighbor
+	EventRecv   EventType = "recv"   // a peer received a message from a neighbor, or published it itself
+	EventExpire EventType = "expire" // a peer dropped a message because its TTL had passed
+)
+
+// TraceEvent is a single send or receive event recorded by a peer during a run.
+// Control messages that do not refer to a single message, such as digests, are recorded with an empty ID.
+type TraceEvent struct {
+	Peer     PeerID      `json:"peer"`           // ID of the peer that recorded the event
+	Type     EventType   `json:"type"`           // type of the event
+	Kind     MessageKind `json:"kind,omitempty"` // kind of the message, empty for payload messages
+	From     PeerID      `json:"from"`           // ID of the sender peer
+	To       PeerID      `json:"to"`             // ID of the target peer
+	ID       string      `json:"id"`             // ID of the message
+	Content  string      `json:"content"`        // content of the message
+	Hop      int         `json:"hop"`            // hop count of the message as it was sent or received
+	Time     time.Time   `json:"time"`           // time of the event, virtual with a simulated clock and wall-clock otherwise
+	Elapsed  float64     `json:"elapsed_ms"`     // time of the event since the start of the run, in milliseconds
+	WallTime time.Time   `json:"wall_time"`      // wall-clock time at which the event was recorded
+	First    bool        `json:"first"`          // indicates if this is the first time the receiving peer sees the message
+}
+
+// Trace is a sequence of trace events, ordered by time.
+type Trace []TraceEvent
+
+// Reception describes the first reception of a message at a peer.
+type Reception struct {
+	PeerID    PeerID    `json:"peer_id"`   // ID of the receiving peer
+	From      PeerID    `json:"from"`      // ID of the peer the message was first received from
+	Timestamp time.Time `json:"timestamp"` // time of the first reception
+}
+
+// TraceWriter receives trace events as they are recorded, so that long runs can be streamed to storage.
+// Set it as Config.TraceWriter; implementations must be safe for concurrent use.
+type TraceWriter interface {
+	WriteEvent(ev TraceEvent) error
+}
+
+// csvHeader lists the columns written by CSV trace writers.
+var csvHeader = []string{"peer", "type", "kind", "from", "to", "id", "content", "hop", "time", "elapsed_ms", "wall_time", "first"}
+
+// JSONLTraceWriter writes trace events as JSON Lines, one JSON object per event.
+type JSONLTraceWriter struct {
+	enc *json.Encoder
+	err error
+	mu  sync.Mutex
+}
+
+// NewJSONLTraceWriter creates a trace writer that writes JSON Lines to w.
+func NewJSONLTraceWriter(w io.Writer) *JSONLTraceWriter {
+	return &JSONLTraceWriter{enc: json.NewEncoder(w)}
+}
+
+// WriteEvent writes a single event. After the first error, all further writes are skipped and return that error.
+func (w *JSONLTraceWriter) WriteEvent(ev TraceEvent) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.enc.Encode(ev); err != nil {
+		w.err = fmt.Errorf("failed to write trace event: %v", err)
+	}
+
+	return w.err
+}
+
+// Flush returns the first error that occurred while writing, if any.
+func (w *JSONLTraceWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+// CSVTraceWriter writes trace events as CSV rows, preceded by a header row.
+type CSVTraceWriter struct {
+	w      *csv.Writer
+	header bool
+	err    error
+	mu     sync.Mutex
+}
+
+// NewCSVTraceWriter creates a trace writer that writes CSV to w. Flush must be called once the run is over.
+func NewCSVTraceWriter(w io.Writer) *CSVTraceWriter {
+	return &CSVTraceWriter{w: csv.NewWriter(w)}
+}
+
+// WriteEvent writes a single event. After the first error, all further writes are skipped and return that error.
+func (w *CSVTraceWriter) WriteEvent(ev TraceEvent) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writeHeader()
+	if w.err != nil {
+		return w.err
+	}
+
+	record := []string{
+		string(ev.Peer),
+		string(ev.Type),
+		string(ev.Kind),
+		string(ev.From),
+		string(ev.To),
+		ev.ID,
+		ev.Content,
+		strconv.Itoa(ev.Hop),
+		ev.Time.Format(time.RFC3339Nano),
+		strconv.FormatFloat(ev.Elapsed, 'f', -1, 64),
+		ev.WallTime.Format(time.RFC3339Nano),
+		strconv.FormatBool(ev.First),
+	}
+
+	if err := w.w.Write(record); err != nil {
+		w.err = fmt.Errorf("failed to write trace event: %v", err)
+	}
+
+	return w.err
+}
+
+// Flush writes any buffered rows to the underlying writer, starting with the header row if no event was written, and
+// returns the first error that occurred, if any.
+func (w *CSVTraceWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writeHeader()
+	w.w.Flush()
+
+	if w.err == nil {
+		if err := w.w.Error(); err != nil {
+			w.err = fmt.Errorf("failed to flush trace: %v", err)
+		}
+	}
+
+	return w.err
+}
+
+// writeHeader writes the header row unless it was already written or an error occurred.
+// The caller must hold the writer's mutex.
+func (w *CSVTraceWriter) writeHeader() {
+	if w.header || w.err != nil {
+		return
+	}
+
+	w.header = true
+
+	if err := w.w.Write(csvHeader); err != nil {
+		w.err = fmt.Errorf("failed to write trace header: %v", err)
+	}
+}
+
+/* Trace Export */
+
+// Trace returns every event recorded by every peer during the run, ordered by time and then by peer ID.
+func (p *P2P) Trace() Trace {
+	trace := make(Trace, 0)
+
+	for _, peer := range p.peerList() {
+		peer.mu.Lock()
+
+		ids := make([]string, 0, len(peer.log))
+		for id := range peer.log {
+			ids = append(ids, id)
+		}
+
+		slices.Sort(ids)
+
+		for _, id := range ids {
+			trace = append(trace, peer.log[id]...)
+		}
+
+		peer.mu.Unlock()
+	}
+
+	slices.SortStableFunc(trace, func(a, b TraceEvent) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.Peer, b.Peer)
+	})
+
+	return trace
+}
+
+// WriteJSONL writes the trace to w as JSON Lines.
+func (t Trace) WriteJSONL(w io.Writer) error {
+	tw := NewJSONLTraceWriter(w)
+
+	for _, ev := range t {
+		if err := tw.WriteEvent(ev); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+// WriteCSV writes the trace to w as CSV with a header row.
+func (t Trace) WriteCSV(w io.Writer) error {
+	tw := NewCSVTraceWriter(w)
+
+	for _, ev := range t {
+		if err := tw.WriteEvent(ev); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+/* Utility Functions */
+
+// record stores a trace event in the peer's log and queues it for the configured trace writer, to which writeTrace
+// passes it once the peer's mutex is released. The caller must hold the peer's mutex.
+func (p *P2P) record(peer *peer, ev TraceEvent) {
+	ev.Peer = peer.id
+	ev.WallTime = time.Now()
+	ev.Elapsed = float64(ev.Time.UnixNano()-p.start.Load()) / float64(time.Millisecond)
+
+	peer.log[ev.ID] = append(peer.log[ev.ID], ev)
+
+	if p.cfg.TraceWriter != nil {
+		peer.unwritten = append(peer.unwritten, ev)
+	}
+}
+
+// writeTrace passes the events queued by record to the configured trace writer and keeps its first error.
+// The caller must not hold the peer's mutex, so that a slow writer does not block the peer.
+func (p *P2P) writeTrace(peer *peer) {
+	if p.cfg.TraceWriter == nil {
+		return
+	}
+
+	peer.mu.Lock()
+	events := peer.unwritten
+	peer.unwritten = nil
+	peer.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	p.traceMu.Lock()
+	defer p.traceMu.Unlock()
+
+	for _, ev := range events {
+		if err := p.cfg.TraceWriter.WriteEvent(ev); err != nil && p.traceErr == nil {
+			p.traceErr = err
+		}
+	}
+}
+
+// TraceError returns the first error returned by Config.TraceWriter, or nil if every event was written.
+func (p *P2P) TraceError() error {
+	p.traceMu.Lock()
+	defer p.traceMu.Unlock()
+
+	return p.traceErr
+}
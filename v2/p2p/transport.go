@@ -0,0 +1,355 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport selects how messages travel between peers once their network latency has passed.
+type Transport string
+
+const (
+	TransportMemory Transport = ""    // messages are handed over in memory
+	TransportTCP    Transport = "tcp" // every peer listens on a loopback TCP port and messages are serialized over connections
+	TransportUDP    Transport = "udp" // every peer listens on a loopback UDP port and every message is serialized in one datagram
+)
+
+// maxDatagram is the largest message frame that fits in a UDP datagram over loopback.
+const maxDatagram = 65507
+
+// frame is the serialized form of a message sent over a socket. The protocol travels by its registered name,
+// and static and dynamic parameters and the payload must hold types registered with gob, such as all basic types.
+type frame struct {
+	Sender  PeerID
+	Target  PeerID
+	LastBit time.Time // time at which the last bit leaves the sender's link, to model the target's download link
+
+	Publisher     PeerID
+	From          PeerID
+	ID            string
+	Content       string
+	Size          int
+	HopCount      int
+	Protocol      string
+	StaticParams  map[string]any
+	DynamicParams map[string]any
+	Kind          MessageKind
+	Digest        []string
+	Topic         string
+	Stem          bool
+	Payload       any
+	MaxHops       int
+	TTL           float64
+}
+
+// tcpConn is an outgoing TCP connection from a peer to one of its neighbors.
+type tcpConn struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	mu   sync.Mutex
+}
+
+// socketTransport carries messages between peers over loopback sockets. Latencies are injected by the network
+// before a message is written, so that the kernel's own delay comes on top of the simulated one.
+type socketTransport struct {
+	network string // "tcp" or "udp"
+
+	listeners map[PeerID]net.Listener   // TCP listener of each peer
+	packets   map[PeerID]net.PacketConn // UDP socket of each peer
+	addrs     map[PeerID]net.Addr       // address each peer listens on
+	conns     map[[2]PeerID]*tcpConn    // sender and target -> outgoing TCP connection
+	errors    int                       // number of messages that could not be sent or received
+	mu        sync.Mutex                // mutex to protect access to the transport's state
+}
+
+// newSocketTransport creates a socket transport for the given network, "tcp" or "udp".
+func newSocketTransport(network string) *socketTransport {
+	return &socketTransport{
+		network:   network,
+		listeners: make(map[PeerID]net.Listener),
+		packets:   make(map[PeerID]net.PacketConn),
+		addrs:     make(map[PeerID]net.Addr),
+		conns:     make(map[[2]PeerID]*tcpConn),
+	}
+}
+
+// open makes the peer listen on a loopback port and hands every frame it receives to the network.
+func (t *socketTransport) open(network *P2P, id PeerID) error {
+	switch t.network {
+	case "tcp":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("failed to listen for peer %s: %v", id, err)
+		}
+
+		t.mu.Lock()
+		t.listeners[id] = l
+		t.addrs[id] = l.Addr()
+		t.mu.Unlock()
+
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				go t.readStream(network, conn)
+			}
+		}()
+	default:
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("failed to listen for peer %s: %v", id, err)
+		}
+
+		t.mu.Lock()
+		t.packets[id] = pc
+		t.addrs[id] = pc.LocalAddr()
+		t.mu.Unlock()
+
+		go t.readPackets(network, pc)
+	}
+
+	return nil
+}
+
+// readStream decodes frames from an incoming TCP connection until it is closed.
+func (t *socketTransport) readStream(network *P2P, conn net.Conn) {
+	defer conn.Close()
+
+	dec := gob.NewDecoder(conn)
+
+	for {
+		var f frame
+		if err := dec.Decode(&f); err != nil {
+			if err != io.EOF {
+				t.countError()
+			}
+
+			return
+		}
+
+		network.receiveFrame(f)
+	}
+}
+
+// readPackets decodes one frame from every datagram received on a UDP socket until it is closed.
+func (t *socketTransport) readPackets(network *P2P, pc net.PacketConn) {
+	buf := make([]byte, maxDatagram)
+
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		var f frame
+		if err := gob.NewDecoder(bytes.NewReader(buf[:n])).Decode(&f); err != nil {
+			t.countError()
+			continue
+		}
+
+		network.receiveFrame(f)
+	}
+}
+
+// send serializes the message and writes it to the target's socket. Messages that cannot be sent are dropped.
+func (t *socketTransport) send(senderID, targetID PeerID, msg Message, lastBit time.Time) {
+	f := frame{
+		Sender:        senderID,
+		Target:        targetID,
+		LastBit:       lastBit,
+		Publisher:     msg.Publisher,
+		From:          msg.From,
+		ID:            msg.ID,
+		Content:       msg.Content,
+		Size:          msg.Size,
+		HopCount:      msg.HopCount,
+		Protocol:      msg.ProtocolName,
+		StaticParams:  msg.StaticParams,
+		DynamicParams: msg.DynamicParams,
+		Kind:          msg.Kind,
+		Digest:        msg.Digest,
+		Topic:         msg.Topic,
+		Stem:          msg.Stem,
+		Payload:       msg.Payload,
+		MaxHops:       msg.MaxHops,
+		TTL:           msg.TTL,
+	}
+
+	var err error
+	if t.network == "tcp" {
+		err = t.sendStream(f)
+	} else {
+		err = t.sendPacket(f)
+	}
+
+	if err != nil {
+		t.countError()
+	}
+}
+
+// sendStream writes a frame over the sender's connection to the target, dialing it on first use.
+func (t *socketTransport) sendStream(f frame) error {
+	key := [2]PeerID{f.Sender, f.Target}
+
+	t.mu.Lock()
+	c, ok := t.conns[key]
+	addr, found := t.addrs[f.Target]
+	t.mu.Unlock()
+
+	if !ok {
+		if !found {
+			return fmt.Errorf("peer %s has no socket", f.Target)
+		}
+
+		// dial without holding the lock, so that a slow dial does not block the other senders
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			return fmt.Errorf("failed to dial peer %s: %v", f.Target, err)
+		}
+
+		t.mu.Lock()
+		_, senderOpen := t.addrs[f.Sender]
+		_, targetOpen := t.addrs[f.Target]
+		if !senderOpen || !targetOpen {
+			// a peer was closed while dialing, and its connections must not outlive it
+			t.mu.Unlock()
+			conn.Close()
+			return fmt.Errorf("connection from peer %s to %s was closed while dialing", f.Sender, f.Target)
+		}
+		if existing, dialed := t.conns[key]; dialed {
+			conn.Close()
+			c = existing
+		} else {
+			c = &tcpConn{conn: conn, enc: gob.NewEncoder(conn)}
+			t.conns[key] = c
+		}
+		t.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.enc.Encode(f)
+}
+
+// sendPacket writes a frame in a single datagram from the sender's socket to the target's.
+func (t *socketTransport) sendPacket(f frame) error {
+	t.mu.Lock()
+	pc, ok := t.packets[f.Sender]
+	addr, found := t.addrs[f.Target]
+	t.mu.Unlock()
+
+	if !ok || !found {
+		return fmt.Errorf("peer %s or %s has no socket", f.Sender, f.Target)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(f); err != nil {
+		return err
+	}
+	if buf.Len() > maxDatagram {
+		return fmt.Errorf("message of %d bytes does not fit in a datagram", buf.Len())
+	}
+
+	_, err := pc.WriteTo(buf.Bytes(), addr)
+
+	return err
+}
+
+// close releases the sockets of the peer and the connections from and to it.
+func (t *socketTransport) close(id PeerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.listeners[id]; ok {
+		l.Close()
+		delete(t.listeners, id)
+	}
+	if pc, ok := t.packets[id]; ok {
+		pc.Close()
+		delete(t.packets, id)
+	}
+	delete(t.addrs, id)
+
+	for key, c := range t.conns {
+		if key[0] == id || key[1] == id {
+			c.conn.Close()
+			delete(t.conns, key)
+		}
+	}
+}
+
+// closeAll releases every socket of the transport.
+func (t *socketTransport) closeAll() {
+	t.mu.Lock()
+	ids := make([]PeerID, 0, len(t.addrs))
+	for id := range t.addrs {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+
+	for _, id := range ids {
+		t.close(id)
+	}
+}
+
+// countError increments the number of messages that could not be sent or received.
+func (t *socketTransport) countError() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.errors++
+}
+
+// receiveFrame rebuilds a message received over a socket and lets it arrive at the target.
+func (p *P2P) receiveFrame(f frame) {
+	sender, ok := p.peer(f.Sender)
+	if !ok {
+		return
+	}
+
+	msg := Message{
+		Publisher:     f.Publisher,
+		From:          f.From,
+		ID:            f.ID,
+		Content:       f.Content,
+		Size:          f.Size,
+		HopCount:      f.HopCount,
+		Protocol:      p.protocols[f.Protocol],
+		ProtocolName:  f.Protocol,
+		StaticParams:  f.StaticParams,
+		DynamicParams: f.DynamicParams,
+		Rand:          p.rng,
+		Kind:          f.Kind,
+		Digest:        f.Digest,
+		Topic:         f.Topic,
+		Stem:          f.Stem,
+		Payload:       f.Payload,
+		MaxHops:       f.MaxHops,
+		TTL:           f.TTL,
+	}
+
+	p.arrive(sender, f.Target, msg, f.LastBit)
+}
+
+// TransportErrors returns the number of messages dropped by the socket transport because they could not be
+// serialized, sent or received, such as messages with an unregistered protocol or too large for a datagram.
+// It is always zero with the in-memory transport.
+func (p *P2P) TransportErrors() int {
+	if p.transport == nil {
+		return 0
+	}
+
+	p.transport.mu.Lock()
+	defer p.transport.mu.Unlock()
+
+	return p.transport.errors
+}
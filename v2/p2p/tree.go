@@ -0,0 +1,62 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elecbug/netkit/v2/graph"
+)
+
+// DisseminationTree builds the dissemination tree of the specified message as a directed graph, with an edge from
+// the peer each node first received the message from to the node itself. The publisher is the root of the tree.
+// If weighted is true, each edge is weighted by the observed per-hop latency in milliseconds, that is the time between
+// the first receptions at the parent and at the child, which includes the parent's processing latency.
+// It returns an error if the message was never received, or if a weighted edge would have a non-positive latency.
+func (p *P2P) DisseminationTree(msg string, weighted bool) (*graph.Graph, error) {
+	receptions := p.FirstMessageReceptions(msg)
+	if len(receptions) == 0 {
+		return nil, fmt.Errorf("message %s was not received by any peer", msg)
+	}
+
+	tree := graph.New(true, weighted)
+	seenAt := make(map[PeerID]time.Time)
+
+	for _, rec := range receptions {
+		if err := tree.AddNode(graph.NodeID(rec.PeerID)); err != nil {
+			return nil, fmt.Errorf("failed to add node %s: %v", rec.PeerID, err)
+		}
+
+		seenAt[rec.PeerID] = rec.Timestamp
+	}
+
+	for _, rec := range receptions {
+		parent, child := rec.From, rec.PeerID
+
+		if parent == child {
+			continue
+		}
+
+		parentAt, ok := seenAt[parent]
+		if !ok {
+			// the parent has been removed from the network since
+			continue
+		}
+
+		var weight *graph.Weight
+
+		if weighted {
+			latency := float64(rec.Timestamp.Sub(parentAt)) / float64(time.Millisecond)
+			if latency <= 0 {
+				return nil, fmt.Errorf("non-positive latency %f on edge from %s to %s", latency, parent, child)
+			}
+
+			weight = graph.NewWeight(latency)
+		}
+
+		if err := tree.AddEdge(graph.NodeID(parent), graph.NodeID(child), weight); err != nil {
+			return nil, fmt.Errorf("failed to add edge from %s to %s: %v", parent, child, err)
+		}
+	}
+
+	return tree, nil
+}
@@ -0,0 +1,57 @@
+package p2p
+
+// hopLimited reports whether a payload message has reached its hop limit, so that it must not be forwarded.
+func hopLimited(msg Message) bool {
+	return msg.MaxHops > 0 && msg.HopCount >= msg.MaxHops
+}
+
+// expired reports whether more than the TTL of a message has passed since it was published.
+func (p *P2P) expired(msg Message) bool {
+	if msg.TTL <= 0 {
+		return false
+	}
+
+	p.mu.RLock()
+	pub, ok := p.published[msg.ID]
+	p.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	return p.now().Sub(pub.at) > milliseconds(msg.TTL)
+}
+
+// expire records that the peer dropped a message because its TTL had passed.
+func (p *peer) expire(network *P2P, msg Message) {
+	defer network.writeTrace(p)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expired[msg.ID]++
+
+	network.record(p, TraceEvent{
+		Type:    EventExpire,
+		From:    msg.From,
+		To:      p.id,
+		ID:      msg.ID,
+		Content: msg.Content,
+		Hop:     msg.HopCount,
+		Time:    network.now(),
+	})
+}
+
+// ExpiredMessageCount returns the number of times the specified message was dropped because its TTL had passed,
+// on arrival at a peer or before a peer forwarded it.
+func (p *P2P) ExpiredMessageCount(msg string) int {
+	count := 0
+
+	for _, peer := range p.peerList() {
+		peer.mu.Lock()
+		count += peer.expired[msg]
+		peer.mu.Unlock()
+	}
+
+	return count
+}
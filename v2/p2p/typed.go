@@ -0,0 +1,114 @@
+package p2p
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// paramsKey is the static parameter under which typed protocols receive their parameters.
+const paramsKey = "params"
+
+// Validator is implemented by parameters and payloads that can check themselves before a message is published.
+type Validator interface {
+	Validate() error
+}
+
+// TypedProtocolFunc is a protocol whose parameters have type P and whose messages carry a payload of type T.
+// It takes the current peer's ID, the message being processed and its payload, the neighbor IDs, the peers the message
+// has been sent to and received from and the parameters of the message, and returns the peers to forward it to.
+type TypedProtocolFunc[P any, T any] func(id PeerID, msg Message, payload T, neighbors, sentPeers, receivedPeers []PeerID, params P) []PeerID
+
+// TypedProtocol adapts a TypedProtocolFunc to the network, which runs it as a ProtocolFunc.
+type TypedProtocol[P any, T any] struct {
+	fn ProtocolFunc
+}
+
+// NewTypedProtocol creates a typed protocol from its forwarding function.
+func NewTypedProtocol[P any, T any](forward TypedProtocolFunc[P, T]) *TypedProtocol[P, T] {
+	return &TypedProtocol[P, T]{
+		fn: func(id PeerID, msg Message, neighbors []PeerID, sentPeers []PeerID, receivedPeers []PeerID, staticParams, dynamicParams map[string]any) (*[]PeerID, map[PeerID]map[string]any) {
+			targets := make([]PeerID, 0)
+
+			params, ok := staticParams[paramsKey].(P)
+			if !ok {
+				return &targets, nil
+			}
+
+			payload, _ := msg.Payload.(T)
+			targets = append(targets, forward(id, msg, payload, neighbors, sentPeers, receivedPeers, params)...)
+
+			return &targets, nil
+		},
+	}
+}
+
+// Func returns the ProtocolFunc that runs the typed protocol, which reads the parameters set by PublishTyped.
+func (tp *TypedProtocol[P, T]) Func() ProtocolFunc {
+	return tp.fn
+}
+
+// PublishTyped publishes a message with the given ID carrying a typed payload from the specified peer, to be
+// forwarded by a typed protocol with typed parameters. The payload and the parameters are validated first if they
+// implement Validator. Typed protocols cannot be used with socket transports, whose messages name their protocol.
+func PublishTyped[P any, T any](p *P2P, id PeerID, msgID string, payload T, protocol *TypedProtocol[P, T], params P) error {
+	if msgID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+	if p.transport != nil {
+		return fmt.Errorf("typed protocols cannot be used with the %s transport", p.cfg.Transport)
+	}
+	if err := validate(payload); err != nil {
+		return fmt.Errorf("invalid payload for message %s: %v", msgID, err)
+	}
+
+	return p.PublishMessage(id, Message{
+		ID:           msgID,
+		Content:      msgID,
+		Protocol:     protocol.fn,
+		StaticParams: map[string]any{paramsKey: params},
+		Payload:      payload,
+	})
+}
+
+// validate calls Validate on v, or on a pointer to v, if it implements Validator.
+func validate(v any) error {
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+
+	if validator, ok := ptr.Interface().(Validator); ok {
+		return validator.Validate()
+	}
+
+	return nil
+}
+
+// validateStatic checks the static parameters of a message by their values rather than by its protocol function:
+// typed parameters, as set by PublishTyped, are validated if they implement Validator, and the parameters of Gossip
+// whenever one of them is set.
+func validateStatic(staticParams map[string]any) error {
+	if params, ok := staticParams[paramsKey]; ok {
+		if err := validate(params); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := staticParams["gossip_factor"]; ok {
+		_, err := gossipParams(staticParams)
+		return err
+	}
+	if _, ok := staticParams["gossip_node"]; ok {
+		_, err := gossipParams(staticParams)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package p2p
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// WorkloadConfig describes a stream of messages published at the arrival times of a Poisson process. Every message is
+// a copy of Template with its own ID, so that many concurrent messages may share the same content.
+type WorkloadConfig struct {
+	Rate       float64                 // mean number of messages published per second
+	Count      int                     // number of messages to publish
+	Publishers []PeerID                // peers that may publish the messages; empty means all peers
+	WeightFunc func(id PeerID) float64 // relative probability of each publisher being chosen; nil chooses uniformly
+	Prefix     string                  // prefix of the message IDs, followed by a number that is unique among the workloads of the network; empty means "msg-"
+	Template   Message                 // message to publish, whose Content defaults to the message ID if empty
+}
+
+// StartWorkload schedules the publications of the workload and returns the IDs of its messages. Each message is
+// published by a publisher drawn from the network's random source among those alive at its publish time, and is
+// skipped if none is alive. Publications are scheduled from the current time, so the workload runs with the network.
+// Messages that fail to be published, for instance because of invalid parameters, are skipped and the first error is
+// reported by WorkloadError.
+func (p *P2P) StartWorkload(cfg WorkloadConfig) ([]string, error) {
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("workload rate must be positive")
+	}
+	if cfg.Count < 0 {
+		return nil, fmt.Errorf("workload count must be non-negative")
+	}
+
+	for _, id := range cfg.Publishers {
+		if _, ok := p.peer(id); !ok {
+			return nil, fmt.Errorf("peer %s not found", id)
+		}
+	}
+
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "msg-"
+	}
+
+	// number the messages after those of previous workloads, so that their IDs are unique
+	p.mu.Lock()
+	first := p.workIDs
+	p.workIDs += cfg.Count
+	p.mu.Unlock()
+
+	ids := make([]string, cfg.Count)
+	at := 0.0
+
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s%d", prefix, first+i)
+		at += p.rng.ExpFloat64() / cfg.Rate * 1000
+
+		msg := cfg.Template
+		msg.ID = ids[i]
+		if msg.Content == "" {
+			msg.Content = ids[i]
+		}
+
+		p.after(at, func() {
+			if id, ok := p.drawPublisher(cfg); ok {
+				if err := p.PublishMessage(id, msg); err != nil {
+					p.mu.Lock()
+					if p.workErr == nil {
+						p.workErr = fmt.Errorf("failed to publish workload message %s: %v", msg.ID, err)
+					}
+					p.mu.Unlock()
+				}
+			}
+		})
+	}
+
+	return ids, nil
+}
+
+// WorkloadError returns the first error of a workload publication, or nil if every message was published.
+func (p *P2P) WorkloadError() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.workErr
+}
+
+// drawPublisher draws a publisher of the workload among the candidates that are alive.
+func (p *P2P) drawPublisher(cfg WorkloadConfig) (PeerID, bool) {
+	candidates := slices.Clone(cfg.Publishers)
+	if len(candidates) == 0 {
+		candidates = p.PeerIDs()
+	}
+
+	slices.Sort(candidates)
+
+	alive := make([]PeerID, 0, len(candidates))
+	weights := make([]float64, 0, len(candidates))
+	total := 0.0
+
+	for _, id := range candidates {
+		n, ok := p.peer(id)
+		if !ok || !n.isAlive() {
+			continue
+		}
+
+		weight := 1.0
+		if cfg.WeightFunc != nil {
+			weight = max(cfg.WeightFunc(id), 0)
+		}
+
+		alive = append(alive, id)
+		weights = append(weights, weight)
+		total += weight
+	}
+
+	if total <= 0 {
+		return "", false
+	}
+
+	x := p.rng.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return alive[i], true
+		}
+		x -= w
+	}
+
+	return alive[len(alive)-1], true
+}
+
+// Messages returns the IDs of every message published in the network, ordered by publish time and then by ID.
+func (p *P2P) Messages() []string {
+	p.mu.RLock()
+
+	ids := make([]string, 0, len(p.published))
+	for id := range p.published {
+		ids = append(ids, id)
+	}
+
+	published := p.published
+	slices.SortFunc(ids, func(a, b string) int {
+		if c := published[a].at.Compare(published[b].at); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a, b)
+	})
+
+	p.mu.RUnlock()
+
+	return ids
+}